@@ -2,28 +2,47 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/go-github/v53/github"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type AppContext struct {
-	jwksLastUpdate    time.Time
-	appTransport      *ghinstallation.AppsTransport
-	webhook_secret    string
-	configRepo        string
-	configFile        string
-	wellKnownURL      string
-	jwksCache         []byte
+	appTransport   *ghinstallation.AppsTransport
+	webhook_secret string
+	configRepo     string
+	configFile     string
+	// Full name of the ref the configuration is loaded from, the default branch of the config repository when empty
+	configRef string
+	// How a config repository is read, through a clone or through the API
+	configLoader      string
+	authenticator     Authenticator
+	replayCache       ReplayCache
+	auditSink         AuditSink
 	installationCache *InstallationCache
 	configCache       *ConfigCache
+	statusCache       *StatusCache
 	gitURL            string
+	// Issuer of the OIDC tokens of GitHub Actions, the entitlements which don't set iss only match its tokens
+	gitHubIssuer string
+	// Bearer token required by the admin endpoints, which are disabled when empty
+	adminToken string
+	// Set once the installations have been listed and their configuration loaded at startup
+	ready atomic.Bool
 }
 
 type ScopedTokenRequest struct {
@@ -32,20 +51,84 @@ type ScopedTokenRequest struct {
 }
 
 type ScopedTokenResponse struct {
-	ScopedToken    string `json:"scopedToken"`
-	InstallationId int64  `json:"installationId"`
-	Message        string `json:"message"`
+	ScopedToken    string     `json:"scopedToken"`
+	InstallationId int64      `json:"installationId"`
+	Message        string     `json:"message"`
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty"`
 }
 
-func NewAppContext(jwksLastUpdate time.Time, appTransport *ghinstallation.AppsTransport,
-	webhook_secret string, configRepo string, configFile string, wellKnownURL string, gitUrl string) *AppContext {
-	installationCache := NewInstallationCache()
-	configCache := NewConfigCache()
+/*
+ * Implemented by the dependencies which need to be ready before the app can serve requests
+ */
+type ReadinessChecker interface {
+	Ready() error
+}
+
+type ReadinessResponse struct {
+	Ready   bool     `json:"ready"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+type StatusResponse struct {
+	Ready         bool                 `json:"ready"`
+	Installations []InstallationStatus `json:"installations,omitempty"`
+}
+
+/*
+ * State of a cached configuration, as reported by the admin endpoint
+ */
+type ConfigState struct {
+	Login          string    `json:"login"`
+	InstallationId int64     `json:"installationId"`
+	Repo           string    `json:"repo"`
+	File           string    `json:"file,omitempty"`
+	Ref            string    `json:"ref,omitempty"`
+	CommitSHA      string    `json:"commitSha,omitempty"`
+	LoadedAt       time.Time `json:"loadedAt"`
+	LoadError      string    `json:"loadError,omitempty"`
+	// Error of the last reload, the configuration being the last one which loaded
+	ReloadError      string   `json:"reloadError,omitempty"`
+	EntitlementCount int      `json:"entitlementCount"`
+	Quarantined      []string `json:"quarantined"`
+}
 
+type EntitlementExplanation struct {
+	Source        string `json:"source"`
+	Effect        string `json:"effect"`
+	Matched       bool   `json:"matched"`
+	FailedClaim   string `json:"failedClaim,omitempty"`
+	ExpectedValue string `json:"expectedValue,omitempty"`
+	Scopes        Scope  `json:"scopes"`
+}
+
+type ExplainResponse struct {
+	Login          string                   `json:"login"`
+	InstallationId int64                    `json:"installationId"`
+	Claims         jwt.MapClaims            `json:"claims"`
+	Entitlements   []EntitlementExplanation `json:"entitlements"`
+	Scope          *Scope                   `json:"scope"`
+	Message        string                   `json:"message,omitempty"`
+}
+
+func NewAppContext(appTransport *ghinstallation.AppsTransport,
+	webhook_secret string, configRepo string, configFile string, configRef string, configLoader string, authenticator Authenticator, replayCache ReplayCache, auditSink AuditSink, gitUrl string, gitHubIssuer string, adminToken string) *AppContext {
 	return &AppContext{
-		jwksLastUpdate, appTransport,
-		webhook_secret, configRepo, configFile, wellKnownURL,
-		nil, installationCache, configCache, gitUrl}
+		appTransport:      appTransport,
+		webhook_secret:    webhook_secret,
+		configRepo:        configRepo,
+		configFile:        configFile,
+		configRef:         fullRefName(configRef),
+		configLoader:      configLoader,
+		authenticator:     authenticator,
+		replayCache:       replayCache,
+		auditSink:         auditSink,
+		installationCache: NewInstallationCache(),
+		configCache:       NewConfigCache(),
+		statusCache:       NewStatusCache(),
+		gitURL:            gitUrl,
+		gitHubIssuer:      gitHubIssuer,
+		adminToken:        adminToken,
+	}
 }
 
 func (appContext *AppContext) loadConfigs() error {
@@ -77,25 +160,45 @@ func (appContext *AppContext) loadConfigs() error {
 }
 
 func (appContext *AppContext) loadConfig(login string, installationId int64) error {
-	config := NewEntitlementConfig(login, installationId, appContext.gitURL, appContext.configRepo, appContext.configFile)
+	_, err := appContext.loadConfigChanges(login, installationId, nil)
+	return err
+}
 
-	err := config.load(appContext.appTransport)
-	if err != nil {
-		// Shall we do something with this error besides logging it? As it is, the entry is in the cache so
-		// we don't try to reload a faulty configuration for each call. Users will not get a token
+/*
+ * Load the configuration of an installation, only parsing the changed files again when changes are given.
+ * The configuration is built off to the side and only replaces the cached one once it fully loaded. Return the configuration which was loaded, whether it is in use or not.
+ */
+func (appContext *AppContext) loadConfigChanges(login string, installationId int64, changes *ConfigChanges) (*EntitlementConfig, error) {
+	config := NewEntitlementConfig(login, installationId, appContext.gitURL, appContext.configRepo, appContext.configFile)
+	config.Ref = appContext.configRef
+	config.Loader = appContext.configLoader
+	config.GitHubIssuer = appContext.gitHubIssuer
+
+	appContext.statusCache.SetLoading(login, installationId)
+	err := config.loadAndRecord(appContext.appTransport, changes)
+	appContext.statusCache.RecordLoad(login, installationId, err)
+
+	inUse := appContext.configCache.SwapConfig(login, config)
+	recordConfigReload(inUse, err)
+	if err != nil && inUse != config {
+		log.Printf("failed to load config for installation %d on org %s with error %s, keeping the config from commit %s loaded at %s\n", installationId, login, err, inUse.CommitSHA, inUse.LoadedAt.Format(time.RFC3339))
+	} else if err != nil {
+		// There is no configuration to fall back to, users will not get a token until the configuration is fixed
 		log.Printf("failed to load config for installation %d on org %s with error %s\n", installationId, login, err)
+	} else {
+		log.Printf("updating config cache for login %s\n", login)
 	}
 
-	appContext.configCache.SetConfig(login, config)
-	log.Printf("updating config cache for login %s\n", login)
-
-	return nil
+	return config, err
 }
 
 /*
  * Once the scope has been computed, we can connect to GitHub as an installation and retrieve a scoped token
  */
-func (appContext *AppContext) generateScopedToken(scope *Scope, login string) (ScopedTokenResponse, error) {
+func (appContext *AppContext) generateScopedToken(ctx context.Context, scope *Scope, login string) (response ScopedTokenResponse, err error) {
+	ctx, span := tracer.Start(ctx, "generateScopedToken", trace.WithAttributes(attribute.String("github.login", login)))
+	defer func() { endSpan(span, err) }()
+
 	installationId := appContext.installationCache.GetInstallationId(login)
 	if installationId == 0 {
 		return ScopedTokenResponse{Message: "no installation found"}, nil
@@ -108,62 +211,181 @@ func (appContext *AppContext) generateScopedToken(scope *Scope, login string) (S
 	opts := &github.InstallationTokenOptions{Repositories: scope.Repositories, Permissions: &scope.Permissions}
 
 	client := github.NewClient(&http.Client{Transport: appContext.appTransport})
-	token, _, err := client.Apps.CreateInstallationToken(context.Background(), installationId, opts)
+	start := time.Now()
+	token, _, err := client.Apps.CreateInstallationToken(ctx, installationId, opts)
+	observeDuration(createInstallationTokenDuration, start, err)
 	if err != nil {
 		return ScopedTokenResponse{}, err
 	}
 
-	return ScopedTokenResponse{InstallationId: installationId, ScopedToken: token.GetToken()}, nil
+	expiresAt := token.GetExpiresAt().Time
+	return ScopedTokenResponse{InstallationId: installationId, ScopedToken: token.GetToken(), ExpiresAt: &expiresAt}, nil
 }
 
 /*
- * Received a request to deliver a scoped token for a given OIDC token
+ * Count the request by outcome and send its audit event to the audit sink, if any
  */
-func (appContext *AppContext) handleTokenRequest(w http.ResponseWriter, req *http.Request) {
-	defer req.Body.Close()
+func (appContext *AppContext) recordRequest(event *AuditEvent) {
+	if event.Action == "token" {
+		tokenRequestsTotal.WithLabelValues(event.Outcome).Inc()
+	}
+	if appContext.auditSink == nil {
+		return
+	}
+	if err := appContext.auditSink.Write(event); err != nil {
+		log.Println("failed to write audit event:", err)
+	}
+}
+
+/*
+ * Read the body of a token or explain request and check that the OIDC token it contains came from GitHub.
+ * An error response has already been sent and the outcome set on the audit event when false is returned.
+ */
+func (appContext *AppContext) readScopedTokenRequest(w http.ResponseWriter, req *http.Request, event *AuditEvent) (ScopedTokenRequest, jwt.MapClaims, bool) {
+	var scopedTokenRequest ScopedTokenRequest
 
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
+		event.setOutcome(OutcomeBadRequest, err.Error())
 		http.Error(w, http.StatusText(http.StatusNoContent), http.StatusNoContent)
-		return
+		return scopedTokenRequest, nil, false
 	}
 
-	var scopedTokenRequest ScopedTokenRequest
 	err = json.Unmarshal([]byte(body), &scopedTokenRequest)
 	if err != nil {
+		event.setOutcome(OutcomeBadRequest, err.Error())
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-		return
+		return scopedTokenRequest, nil, false
 	}
-
-	// Check that the OIDC token verifies as a valid token from GitHub
-	claims, err := validateTokenCameFromGitHub(scopedTokenRequest.OIDCToken, appContext)
+	event.Login = scopedTokenRequest.Login
+
+	// Check that the OIDC token verifies as a valid token from GitHub or another trusted issuer
+	start := time.Now()
+	_, span := tracer.Start(req.Context(), "authenticate")
+	claims, err := appContext.authenticator.Authenticate(scopedTokenRequest.OIDCToken)
+	endSpan(span, err)
+	observeDuration(oidcValidationDuration, start, err)
 	if err != nil {
 		log.Println("couldn't validate OIDC token provenance:", err)
-		http.Error(w, "couldn't validate OIDC token provenance", http.StatusUnauthorized)
-		return
+		event.setOutcome(OutcomeInvalidOIDC, err.Error())
+		http.Error(w, fmt.Sprintf("couldn't validate OIDC token provenance: %s", describeTokenError(err)), http.StatusUnauthorized)
+		return scopedTokenRequest, nil, false
 	}
+	event.setClaims(claims)
+	return scopedTokenRequest, claims, true
+}
 
-	// Token is valid. We now need to generate a new token that is specific to our use case
-	config := appContext.configCache.GetConfig(scopedTokenRequest.Login)
+/*
+ * Get the cached configuration of the login targeted by a request and check that the installation policy allows the requester.
+ * An error response has already been sent and the outcome set on the audit event when false is returned.
+ */
+func (appContext *AppContext) getConfigForRequest(w http.ResponseWriter, login string, claims jwt.MapClaims, event *AuditEvent) (*EntitlementConfig, bool) {
+	config := appContext.configCache.GetConfig(login)
 	if config == nil {
-		msg := fmt.Sprintf("no configuration found in cache for %s", scopedTokenRequest.Login)
+		msg := fmt.Sprintf("no configuration found in cache for %s", login)
 		log.Println(msg)
+		event.setOutcome(OutcomeNoConfig, msg)
 		http.Error(w, msg, http.StatusNotFound)
+		return nil, false
+	}
+
+	// A partially loaded configuration could miss deny entitlements
+	if config.LoadError != "" {
+		msg := fmt.Sprintf("configuration of %s failed to load", login)
+		log.Printf("%s: %s", msg, config.LoadError)
+		event.setOutcome(OutcomeNoConfig, config.LoadError)
+		http.Error(w, msg, http.StatusServiceUnavailable)
+		return nil, false
+	}
+
+	if !config.allows(claims) {
+		msg := fmt.Sprintf("repository owner %v is not allowed to request tokens from %s", claims["repository_owner"], login)
+		log.Printf("%s, repository_owner_id: %v", msg, claims["repository_owner_id"])
+		event.setOutcome(OutcomeForbidden, msg)
+		http.Error(w, msg, http.StatusForbidden)
+		return nil, false
+	}
+	return config, true
+}
+
+/*
+ * Received a request to deliver a scoped token for a given OIDC token
+ */
+func (appContext *AppContext) handleTokenRequest(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	event := NewAuditEvent("token", req)
+	defer appContext.recordRequest(event)
+
+	scopedTokenRequest, claims, ok := appContext.readScopedTokenRequest(w, req, event)
+	if !ok {
+		return
+	}
+
+	// Each OIDC token can only be exchanged a limited number of times
+	if appContext.replayCache != nil {
+		allowed, err := useToken(appContext.replayCache, claims)
+		if errors.Is(err, jwt.ErrTokenRequiredClaimMissing) {
+			log.Println("couldn't check OIDC token replay:", err)
+			event.setOutcome(OutcomeInvalidOIDC, err.Error())
+			http.Error(w, fmt.Sprintf("couldn't check OIDC token replay: %s", describeTokenError(err)), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Println("couldn't check OIDC token replay:", err)
+			event.setOutcome(OutcomeInternalError, err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			log.Printf("OIDC token %v has already been used for claims: %v\n", claims["jti"], claims)
+			event.setOutcome(OutcomeReplayed, "OIDC token has already been used")
+			http.Error(w, "OIDC token has already been used", http.StatusUnauthorized)
+			return
+		}
+		// Only the uses which ended up with a scoped token count
+		defer func() {
+			if event.Outcome == OutcomeIssued {
+				return
+			}
+			if err := releaseToken(appContext.replayCache, claims); err != nil {
+				log.Println("couldn't release OIDC token use:", err)
+			}
+		}()
+	}
+
+	// Token is valid. We now need to generate a new token that is specific to our use case
+	config, ok := appContext.getConfigForRequest(w, scopedTokenRequest.Login, claims, event)
+	if !ok {
 		return
 	}
+	_, span := tracer.Start(req.Context(), "computeScopes")
 	scope := config.computeScopes(claims)
+	event.MatchedEntitlements = config.matchingSources(claims)
+	span.SetAttributes(attribute.Int("entitlements.matched", len(event.MatchedEntitlements)))
+	span.End()
 
-	scopedTokenResponse, err := appContext.generateScopedToken(scope, scopedTokenRequest.Login)
+	scopedTokenResponse, err := appContext.generateScopedToken(req.Context(), scope, scopedTokenRequest.Login)
 	if err != nil {
 		log.Printf("failed to generate scoped tokens on org %s with permissions %v for claims: %v, %s\n", scopedTokenRequest.Login, scope, claims, err)
+		event.setOutcome(OutcomeGitHubError, err.Error())
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	event.InstallationId = scopedTokenResponse.InstallationId
 
 	if scopedTokenResponse.ScopedToken == "" {
 		log.Printf("no token generated for claims: %v\n", claims)
+		if scopedTokenResponse.InstallationId == 0 {
+			event.setOutcome(OutcomeNoInstallation, scopedTokenResponse.Message)
+		} else {
+			event.setOutcome(OutcomeNoScope, scopedTokenResponse.Message)
+		}
 	} else {
 		log.Printf("succesfully generated token for claims: %v, with scopes %s\n", claims, scope.String())
+		event.setOutcome(OutcomeIssued, "")
+		event.setScope(scope)
+		event.TokenExpiresAt = scopedTokenResponse.ExpiresAt
 	}
 
 	// Return the new token to the client
@@ -172,6 +394,49 @@ func (appContext *AppContext) handleTokenRequest(w http.ResponseWriter, req *htt
 	json.NewEncoder(w).Encode(scopedTokenResponse)
 }
 
+/*
+ * Received a request to explain which entitlements match a given OIDC token. No token is generated.
+ */
+func (appContext *AppContext) handleExplainRequest(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	event := NewAuditEvent("explain", req)
+	defer appContext.recordRequest(event)
+
+	scopedTokenRequest, claims, ok := appContext.readScopedTokenRequest(w, req, event)
+	if !ok {
+		return
+	}
+
+	config, ok := appContext.getConfigForRequest(w, scopedTokenRequest.Login, claims, event)
+	if !ok {
+		return
+	}
+
+	explainResponse := ExplainResponse{
+		Login:          scopedTokenRequest.Login,
+		InstallationId: appContext.installationCache.GetInstallationId(scopedTokenRequest.Login),
+		Claims:         claims,
+		// Any valid OIDC token is accepted, the entitlements which don't match the caller are only listed to the admins
+		Entitlements: config.explain(claims, appContext.isAdminRequest(req)),
+		Scope:        config.computeScopes(claims),
+	}
+	if explainResponse.InstallationId == 0 {
+		explainResponse.Message = "no installation found"
+	} else if explainResponse.Scope.isEmpty() {
+		explainResponse.Message = "no scope matching these claims"
+	}
+
+	event.InstallationId = explainResponse.InstallationId
+	event.MatchedEntitlements = config.matchingSources(claims)
+	event.setOutcome(OutcomeExplained, explainResponse.Message)
+	event.setScope(explainResponse.Scope)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(explainResponse)
+}
+
 /*
  * This function is called when a push event is received from GitHub.
  * It might mean the configuration has changed and need to be reloaded.
@@ -179,23 +444,59 @@ func (appContext *AppContext) handleTokenRequest(w http.ResponseWriter, req *htt
 func (appContext *AppContext) processPushEvent(event github.PushEvent) {
 	if appContext.checkConfigChange(event) {
 		log.Printf("reloading config for organization %s\n", event.GetRepo().GetOwner().GetLogin())
-		appContext.loadConfig(event.GetRepo().GetOwner().GetLogin(), event.Installation.GetID())
+		config, _ := appContext.loadConfigChanges(event.GetRepo().GetOwner().GetLogin(), event.Installation.GetID(), appContext.pushChanges(event))
+
+		// Let the author of the change know whether the new configuration is in use
+		if sha, ok := reportCommit(config, event.GetAfter()); ok {
+			if err := appContext.publishConfigReport(config, sha); err != nil {
+				log.Printf("failed to report the reload of the config of %s on commit %s: %s\n", config.Login, sha, err)
+			}
+		} else if event.GetAfter() != "" {
+			log.Printf("not reporting the reload of the config of %s on commit %s as it was loaded from commit %s\n", config.Login, event.GetAfter(), config.CommitSHA)
+		}
 	}
 }
 
+/*
+ * Get the files changed by a push, when they can be parsed again on top of the cached configuration.
+ * nil means the whole configuration needs to be loaded again.
+ */
+func (appContext *AppContext) pushChanges(event github.PushEvent) *ConfigChanges {
+	if appContext.configFile != "" {
+		return nil
+	}
+
+	// The cached configuration needs to be the one the push started from
+	base := appContext.configCache.GetConfig(event.GetRepo().GetOwner().GetLogin())
+	if base == nil || base.LoadError != "" || base.CommitSHA == "" || base.CommitSHA != event.GetBefore() {
+		return nil
+	}
+	// A forced push can remove commits, and the commits listed in a push event are capped
+	if event.GetForced() || len(event.Commits) >= maxPushEventCommits {
+		return nil
+	}
+	return &ConfigChanges{Base: base, After: event.GetAfter(), Paths: pushedPaths(event)}
+}
+
 /*
  * Checking if the configuration has changed
  */
 func (appContext *AppContext) checkConfigChange(event github.PushEvent) bool {
-	// Check if the push event is for the main or master branch.
-	// We are not at this time trying to figure out what the default branch is
-	branch := event.GetRef()
-	if branch != "refs/heads/main" && branch != "refs/heads/master" {
+	// Check if the push event is for the ref the configuration is loaded from: the pinned ref if any, the default branch otherwise
+	configRef := appContext.configRef
+	if configRef == "" {
+		configRef = fullRefName(event.GetRepo().GetDefaultBranch())
+	}
+	if configRef == "" || event.GetRef() != configRef {
 		return false
 	}
 
 	// Check if the push event is for the config repo
 	if appContext.configRepo == event.GetRepo().GetName() {
+		if strings.HasPrefix(configRef, "refs/tags/") {
+			// The commits of a moved tag are not listed, so we need to reload the config regardless of the files
+			return true
+		}
 		if appContext.configFile != "" {
 			// Config is single file based.
 			// Check if the config file is part of one of the commits within this push event
@@ -224,6 +525,12 @@ func (appContext *AppContext) processInstallationEvent(event github.Installation
 
 	if event.GetAction() == "deleted" || event.GetAction() == "suspend" {
 		appContext.configCache.DeleteConfig(login)
+		appContext.statusCache.DeleteStatus(login)
+		entitlementsLoaded.DeletePartialMatch(prometheus.Labels{"login": login})
+		configStale.DeleteLabelValues(login)
+		// Release the memory held by the clone or the snapshot of the config repository
+		dropConfigClone(fmt.Sprintf("%s/%s/%s", appContext.gitURL, login, appContext.configRepo))
+		dropConfigTree(fmt.Sprintf("%s/%s/%s", appContext.gitURL, login, appContext.configRepo))
 	} else if event.GetAction() == "created" || event.GetAction() == "unsuspend" {
 		appContext.loadConfig(login, id)
 		appContext.installationCache.SetInstallationId(login, id)
@@ -231,6 +538,110 @@ func (appContext *AppContext) processInstallationEvent(event github.Installation
 
 }
 
+/*
+ * Load the configuration of every installation at startup, retrying until the installations can be listed
+ */
+func (appContext *AppContext) loadInitialConfigs(retryInterval time.Duration) {
+	for {
+		err := appContext.loadConfigs()
+		if err == nil {
+			appContext.ready.Store(true)
+			log.Println("config cache loaded")
+			return
+		}
+		log.Printf("error while loading config cache, retrying in %s: %s\n", retryInterval, err)
+		time.Sleep(retryInterval)
+	}
+}
+
+/*
+ * Check the app can serve token requests: the configurations have been loaded and the issuers' keys are available
+ */
+func (appContext *AppContext) readiness() ReadinessResponse {
+	reasons := []string{}
+	if !appContext.ready.Load() {
+		reasons = append(reasons, "configurations are not loaded yet")
+	}
+	if checker, ok := appContext.authenticator.(ReadinessChecker); ok {
+		if err := checker.Ready(); err != nil {
+			reasons = append(reasons, err.Error())
+		}
+	}
+	return ReadinessResponse{Ready: len(reasons) == 0, Reasons: reasons}
+}
+
+func (appContext *AppContext) handleReadinessRequest(w http.ResponseWriter, req *http.Request) {
+	readiness := appContext.readiness()
+	w.Header().Set("Content-Type", "application/json")
+	if readiness.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(readiness)
+}
+
+func (appContext *AppContext) handleStatusRequest(w http.ResponseWriter, req *http.Request) {
+	status := StatusResponse{Ready: appContext.readiness().Ready}
+	// The logins and the load errors, which can name repositories and files, are only listed to the admins
+	if appContext.isAdminRequest(req) {
+		status.Installations = appContext.statusCache.GetStatuses()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
+
+/*
+ * Check that the request carries the admin token as a bearer token, never true when no admin token is set
+ */
+func (appContext *AppContext) isAdminRequest(req *http.Request) bool {
+	if appContext.adminToken == "" {
+		return false
+	}
+	token, found := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return found && subtle.ConstantTimeCompare([]byte(token), []byte(appContext.adminToken)) == 1
+}
+
+/*
+ * List the state of every cached configuration, for operators to spot broken configuration repositories
+ */
+func (appContext *AppContext) handleAdminConfigsRequest(w http.ResponseWriter, req *http.Request) {
+	if appContext.adminToken == "" {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if !appContext.isAdminRequest(req) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	states := []ConfigState{}
+	for _, config := range appContext.configCache.GetConfigs() {
+		state := ConfigState{
+			Login:            config.Login,
+			InstallationId:   config.InstallationId,
+			Repo:             config.Repo,
+			File:             config.File,
+			Ref:              config.Ref,
+			CommitSHA:        config.CommitSHA,
+			LoadedAt:         config.LoadedAt,
+			LoadError:        config.LoadError,
+			ReloadError:      config.ReloadError,
+			EntitlementCount: config.EntitlementCount,
+			Quarantined:      []string{},
+		}
+		for _, entitlement := range config.Quarantined {
+			state.Quarantined = append(state.Quarantined, entitlement.Source)
+		}
+		states = append(states, state)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(states)
+}
+
 /*
  * Handle http requests
  */
@@ -244,6 +655,33 @@ func (appContext *AppContext) ServeHTTP(w http.ResponseWriter, req *http.Request
 		return
 	}
 
+	// Liveness probe, the process is up
+	if req.Method == http.MethodGet && req.RequestURI == "/healthz" {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("Ok\n"))
+		return
+	}
+
+	if req.Method == http.MethodGet && req.RequestURI == "/readyz" {
+		appContext.handleReadinessRequest(w, req)
+		return
+	}
+
+	if req.Method == http.MethodGet && req.RequestURI == "/status" {
+		appContext.handleStatusRequest(w, req)
+		return
+	}
+
+	if req.Method == http.MethodGet && req.RequestURI == "/admin/configs" {
+		appContext.handleAdminConfigsRequest(w, req)
+		return
+	}
+
+	if req.Method == http.MethodGet && req.RequestURI == "/metrics" {
+		metricsHandler.ServeHTTP(w, req)
+		return
+	}
+
 	if req.Method == http.MethodPost && req.RequestURI == "/webhook" {
 		payload, err := github.ValidatePayload(req, []byte(appContext.webhook_secret))
 		if err != nil {
@@ -273,6 +711,11 @@ func (appContext *AppContext) ServeHTTP(w http.ResponseWriter, req *http.Request
 		return
 	}
 
+	if req.Method == http.MethodPost && req.RequestURI == "/explain" {
+		appContext.handleExplainRequest(w, req)
+		return
+	}
+
 	if req.Method != http.MethodPost && req.RequestURI != "/token" {
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
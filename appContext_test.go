@@ -1,8 +1,19 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/go-github/v53/github"
 )
 
@@ -10,7 +21,8 @@ func TestFileBasedConfigFileAdded(t *testing.T) {
 	event := github.PushEvent{
 		Ref: github.String("refs/heads/master"),
 		Repo: &github.PushEventRepository{
-			Name: github.String(".github-private"),
+			DefaultBranch: github.String("master"),
+			Name:          github.String(".github-private"),
 			Owner: &github.User{
 				Login: github.String("octodemo"),
 			},
@@ -38,7 +50,8 @@ func TestFileBasedConfigFileRemoved(t *testing.T) {
 	event := github.PushEvent{
 		Ref: github.String("refs/heads/master"),
 		Repo: &github.PushEventRepository{
-			Name: github.String(".github-private"),
+			DefaultBranch: github.String("master"),
+			Name:          github.String(".github-private"),
 			Owner: &github.User{
 				Login: github.String("octodemo"),
 			},
@@ -66,7 +79,8 @@ func TestFileBasedConfigFileModified(t *testing.T) {
 	event := github.PushEvent{
 		Ref: github.String("refs/heads/master"),
 		Repo: &github.PushEventRepository{
-			Name: github.String(".github-private"),
+			DefaultBranch: github.String("master"),
+			Name:          github.String(".github-private"),
 			Owner: &github.User{
 				Login: github.String("octodemo"),
 			},
@@ -94,7 +108,8 @@ func TestFileBasedConfigFileAddedInSecondCommit(t *testing.T) {
 	event := github.PushEvent{
 		Ref: github.String("refs/heads/master"),
 		Repo: &github.PushEventRepository{
-			Name: github.String(".github-private"),
+			DefaultBranch: github.String("master"),
+			Name:          github.String(".github-private"),
 			Owner: &github.User{
 				Login: github.String("octodemo"),
 			},
@@ -127,7 +142,8 @@ func TestFileBasedConfigOtherFileAdded(t *testing.T) {
 	event := github.PushEvent{
 		Ref: github.String("refs/heads/master"),
 		Repo: &github.PushEventRepository{
-			Name: github.String(".github-private"),
+			DefaultBranch: github.String("master"),
+			Name:          github.String(".github-private"),
 			Owner: &github.User{
 				Login: github.String("octodemo"),
 			},
@@ -155,7 +171,8 @@ func TestFileBasedConfigFileAddedToOtherRepo(t *testing.T) {
 	event := github.PushEvent{
 		Ref: github.String("refs/heads/master"),
 		Repo: &github.PushEventRepository{
-			Name: github.String("dummy"),
+			DefaultBranch: github.String("master"),
+			Name:          github.String("dummy"),
 			Owner: &github.User{
 				Login: github.String("octodemo"),
 			},
@@ -183,7 +200,8 @@ func TestRepoBasedConfig(t *testing.T) {
 	event := github.PushEvent{
 		Ref: github.String("refs/heads/master"),
 		Repo: &github.PushEventRepository{
-			Name: github.String(".github-private"),
+			DefaultBranch: github.String("master"),
+			Name:          github.String(".github-private"),
 			Owner: &github.User{
 				Login: github.String("octodemo"),
 			},
@@ -203,7 +221,8 @@ func TestRepoBasedConfigWrongRepo(t *testing.T) {
 	event := github.PushEvent{
 		Ref: github.String("refs/heads/master"),
 		Repo: &github.PushEventRepository{
-			Name: github.String(".dummy"),
+			DefaultBranch: github.String("master"),
+			Name:          github.String(".dummy"),
 			Owner: &github.User{
 				Login: github.String("octodemo"),
 			},
@@ -218,3 +237,259 @@ func TestRepoBasedConfigWrongRepo(t *testing.T) {
 		t.Error("Expected config didn't change")
 	}
 }
+
+func TestRepoBasedConfigDefaultBranch(t *testing.T) {
+	event := github.PushEvent{
+		Ref: github.String("refs/heads/trunk"),
+		Repo: &github.PushEventRepository{
+			DefaultBranch: github.String("trunk"),
+			Name:          github.String(".github-private"),
+			Owner: &github.User{
+				Login: github.String("octodemo"),
+			},
+		},
+	}
+
+	context := AppContext{
+		configRepo: ".github-private",
+	}
+
+	if context.checkConfigChange(event) != true {
+		t.Error("Expected config change")
+	}
+
+	event.Ref = github.String("refs/heads/main")
+	if context.checkConfigChange(event) != false {
+		t.Error("Expected push to another branch than the default branch to be ignored")
+	}
+}
+
+func TestRepoBasedConfigPinnedRef(t *testing.T) {
+	event := github.PushEvent{
+		Ref: github.String("refs/heads/main"),
+		Repo: &github.PushEventRepository{
+			DefaultBranch: github.String("main"),
+			Name:          github.String(".github-private"),
+			Owner: &github.User{
+				Login: github.String("octodemo"),
+			},
+		},
+	}
+
+	context := NewAppContext(nil, "", ".github-private", "oidc_entitlements.json", "production", "", nil, nil, nil, "https://github.com", gitHubIssuerURL, "")
+	if context.configRef != "refs/heads/production" {
+		t.Errorf("Expected a branch name to be expanded, got %s", context.configRef)
+	}
+	if context.checkConfigChange(event) != false {
+		t.Error("Expected push to the default branch to be ignored when a ref is pinned")
+	}
+
+	event.Ref = github.String("refs/heads/production")
+	if context.checkConfigChange(event) != false {
+		t.Error("Expected config didn't change")
+	}
+
+	// The commits of a moved tag are not listed
+	context = NewAppContext(nil, "", ".github-private", "oidc_entitlements.json", "refs/tags/v1", "", nil, nil, nil, "https://github.com", gitHubIssuerURL, "")
+	event.Ref = github.String("refs/tags/v1")
+	if context.checkConfigChange(event) != true {
+		t.Error("Expected config change")
+	}
+}
+
+func TestPolicyForbidsOtherOwners(t *testing.T) {
+	config := NewEntitlementConfig("octodemo", 1, "https://github.com", "oidc_entitlements", "")
+	config.Policy = &Policy{AllowedRepositoryOwnerIds: []int64{2787414}}
+
+	context := AppContext{configCache: NewConfigCache()}
+	context.configCache.SetConfig("octodemo", config)
+
+	recorder := httptest.NewRecorder()
+	_, ok := context.getConfigForRequest(recorder, "octodemo", jwt.MapClaims{"repository_owner": "ziggy", "repository_owner_id": "1"}, &AuditEvent{})
+	if ok || recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected request to be forbidden, but got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	foundConfig, ok := context.getConfigForRequest(recorder, "octodemo", jwt.MapClaims{"repository_owner": "major-tom", "repository_owner_id": "2787414"}, &AuditEvent{})
+	if !ok || foundConfig != config {
+		t.Errorf("Expected request to be allowed, but got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	_, ok = context.getConfigForRequest(recorder, "other", jwt.MapClaims{"repository_owner_id": "2787414"}, &AuditEvent{})
+	if ok || recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected request to not find a configuration, but got %d", recorder.Code)
+	}
+}
+
+func TestFailedConfigIsNotServed(t *testing.T) {
+	config := NewEntitlementConfig("octodemo", 1, "https://github.com", "oidc_entitlements", "")
+	config.LoadError = "test/invalid-repo/generic.json: unknown field \"enviroment\""
+
+	context := AppContext{configCache: NewConfigCache()}
+	context.configCache.SetConfig("octodemo", config)
+
+	recorder := httptest.NewRecorder()
+	event := &AuditEvent{}
+	_, ok := context.getConfigForRequest(recorder, "octodemo", jwt.MapClaims{"repository_owner": "major-tom"}, event)
+	if ok || recorder.Code != http.StatusServiceUnavailable || event.Outcome != OutcomeNoConfig {
+		t.Errorf("Expected failed configuration to not be served, but got %d", recorder.Code)
+	}
+}
+
+func TestFailedReloadKeepsLastKnownGoodConfig(t *testing.T) {
+	context := AppContext{configCache: NewConfigCache()}
+
+	goodConfig := NewEntitlementConfig("octodemo", 1, "https://github.com", "oidc_entitlements", "")
+	goodConfig.CommitSHA = "abc"
+	goodConfig.Entitlements = []Entitlement{{RepositoryOwner: "major-tom"}}
+	if inUse := context.configCache.SwapConfig("octodemo", goodConfig); inUse != goodConfig {
+		t.Fatal("Expected the loaded configuration to be in use")
+	}
+
+	failedConfig := NewEntitlementConfig("octodemo", 1, "https://github.com", "oidc_entitlements", "")
+	failedConfig.CommitSHA = "def"
+	failedConfig.LoadError = "generic.json: unknown field \"enviroment\""
+	inUse := context.configCache.SwapConfig("octodemo", failedConfig)
+	if inUse == failedConfig || inUse.CommitSHA != "abc" || inUse.ReloadError != failedConfig.LoadError || failedConfig.Fallback != inUse {
+		t.Errorf("Expected the previous configuration to stay in use, but got %+v", inUse)
+	}
+	if goodConfig.ReloadError != "" {
+		t.Error("Expected the previous configuration to be copied rather than modified")
+	}
+
+	recorder := httptest.NewRecorder()
+	config, ok := context.getConfigForRequest(recorder, "octodemo", jwt.MapClaims{"repository_owner": "major-tom"}, &AuditEvent{})
+	if !ok || config.CommitSHA != "abc" || len(config.Entitlements) != 1 {
+		t.Errorf("Expected the previous configuration to be served, but got %d", recorder.Code)
+	}
+
+	// The previous configuration is still the one to fall back to after another failed reload
+	failedConfig = NewEntitlementConfig("octodemo", 1, "https://github.com", "oidc_entitlements", "")
+	failedConfig.LoadError = "repository not found"
+	if inUse := context.configCache.SwapConfig("octodemo", failedConfig); inUse.CommitSHA != "abc" || inUse.ReloadError != "repository not found" {
+		t.Errorf("Expected the previous configuration to stay in use, but got %+v", inUse)
+	}
+
+	// The files which are ignored are reported but don't prevent the swap
+	fixedConfig := NewEntitlementConfig("octodemo", 1, "https://github.com", "oidc_entitlements", "")
+	fixedConfig.CommitSHA = "ghi"
+	fixedConfig.Issues = []ValidationIssue{{Source: "owner/ziggy.json", Message: "file is ignored as entitlement files can't be stored directly in the 'owner' folder"}}
+	if inUse := context.configCache.SwapConfig("octodemo", fixedConfig); inUse != fixedConfig || inUse.ReloadError != "" {
+		t.Errorf("Expected the fixed configuration to be in use, but got %+v", inUse)
+	}
+
+	// A configuration which failed to load is cached when there is nothing to fall back to
+	failedConfig = NewEntitlementConfig("ziggy", 2, "https://github.com", "oidc_entitlements", "")
+	failedConfig.LoadError = "repository not found"
+	if inUse := context.configCache.SwapConfig("ziggy", failedConfig); inUse != failedConfig || failedConfig.Fallback != nil {
+		t.Errorf("Expected the failed configuration to be cached, but got %+v", inUse)
+	}
+}
+
+func TestPushedInvalidLayoutKeepsLastKnownGoodConfig(t *testing.T) {
+	api := newFakeTreeAPI()
+	api.commit("c1", map[string]string{
+		"owner/major-tom/contents.json": `{"scopes": {"permissions": {"contents": "read"}}}`,
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/repos/", api)
+	mux.HandleFunc("/app/installations/1/access_tokens", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(github.InstallationToken{Token: github.String("installation-token"), ExpiresAt: &github.Timestamp{Time: time.Now().Add(time.Hour)}})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appTransport, err := ghinstallation.NewAppsTransport(http.DefaultTransport, 1, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	appTransport.BaseURL = server.URL
+	context := NewAppContext(appTransport, "", "oidc_entitlements", "", "", apiConfigLoader, nil, nil, nil, server.URL, gitHubIssuerURL, "")
+	defer dropConfigTree(server.URL + "/octodemo/oidc_entitlements")
+
+	push := func(before string, after string, added string) {
+		context.processPushEvent(github.PushEvent{
+			Ref:          github.String("refs/heads/main"),
+			Before:       github.String(before),
+			After:        github.String(after),
+			Installation: &github.Installation{ID: github.Int64(1)},
+			Repo: &github.PushEventRepository{
+				DefaultBranch: github.String("main"),
+				Name:          github.String("oidc_entitlements"),
+				Owner:         &github.User{Login: github.String("octodemo")},
+			},
+			Commits: []*github.HeadCommit{{Added: []string{added}}},
+		})
+	}
+	push("c0", "c1", "owner/major-tom/contents.json")
+	config := context.configCache.GetConfig("octodemo")
+	if config == nil || config.CommitSHA != "c1" || config.LoadError != "" {
+		t.Fatalf("Expected the configuration of commit c1 to be loaded, but got %+v", config)
+	}
+
+	// Without a permission level folder, the repository permissions of the file would be granted on every repository
+	api.commit("c2", map[string]string{
+		"owner/major-tom/contents.json":      `{"scopes": {"permissions": {"contents": "read"}}}`,
+		"organization/administration/x.json": `{"repository_owner": "major-tom", "scopes": {"permissions": {"contents": "write"}}}`,
+	})
+	push("c1", "c2", "organization/administration/x.json")
+	config = context.configCache.GetConfig("octodemo")
+	if config.CommitSHA != "c1" || config.ReloadError == "" {
+		t.Errorf("Expected the configuration of commit c1 to stay in use, but got commit %s with reload error '%s'", config.CommitSHA, config.ReloadError)
+	}
+	scope := config.computeScopes(jwt.MapClaims{"iss": gitHubIssuerURL, "repository_owner": "major-tom"})
+	if scope.Permissions.GetContents() != "read" {
+		t.Errorf("Expected contents read to be granted, but got %s", scope.String())
+	}
+}
+
+func TestAdminConfigsEndpoint(t *testing.T) {
+	context := NewAppContext(nil, "", "oidc_entitlements", "", "", "", nil, nil, nil, "https://github.com", gitHubIssuerURL, "s3cr3t")
+
+	failedConfig := NewEntitlementConfig("octodemo", 1, "https://github.com", "oidc_entitlements", "")
+	failedConfig.LoadError = "repository not found"
+	loadedConfig := NewEntitlementConfig("major-tom", 2, "https://github.com", "oidc_entitlements", "")
+	loadedConfig.CommitSHA = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	loadedConfig.EntitlementCount = 3
+	loadedConfig.Quarantined = []Entitlement{{Source: "environment/production/wildcard.json"}}
+	context.configCache.SetConfig("octodemo", failedConfig)
+	context.configCache.SetConfig("major-tom", loadedConfig)
+
+	recorder := httptest.NewRecorder()
+	context.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin/configs", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected request without token to be unauthorized, but got %d", recorder.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/configs", nil)
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	recorder = httptest.NewRecorder()
+	context.ServeHTTP(recorder, req)
+
+	var states []ConfigState
+	json.NewDecoder(recorder.Body).Decode(&states)
+	expectedStates := []ConfigState{
+		{Login: "major-tom", InstallationId: 2, Repo: "oidc_entitlements", CommitSHA: "6dcb09b5b57875f334f61aebed695e2e4193db5e", EntitlementCount: 3, Quarantined: []string{"environment/production/wildcard.json"}},
+		{Login: "octodemo", InstallationId: 1, Repo: "oidc_entitlements", LoadError: "repository not found", Quarantined: []string{}},
+	}
+	if !reflect.DeepEqual(expectedStates, states) {
+		expectedJson, _ := json.MarshalIndent(expectedStates, "", "  ")
+		gotJson, _ := json.MarshalIndent(states, "", "  ")
+		t.Errorf("Expected config states to be %s, but got %s", string(expectedJson), string(gotJson))
+	}
+
+	// The admin endpoints are disabled without token
+	context.adminToken = ""
+	recorder = httptest.NewRecorder()
+	context.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected admin endpoint to be disabled, but got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/go-github/v53/github"
+)
+
+// Outcomes of a request, also used as metric labels
+const (
+	OutcomeIssued         = "issued"
+	OutcomeExplained      = "explained"
+	OutcomeBadRequest     = "bad-request"
+	OutcomeInvalidOIDC    = "invalid-oidc"
+	OutcomeReplayed       = "replayed"
+	OutcomeNoConfig       = "no-config"
+	OutcomeForbidden      = "forbidden"
+	OutcomeNoInstallation = "no-installation"
+	OutcomeNoScope        = "no-scope"
+	OutcomeGitHubError    = "github-error"
+	OutcomeInternalError  = "internal-error"
+)
+
+/*
+ * Audit record of a token or explain request, written as a single JSON line
+ */
+type AuditEvent struct {
+	Timestamp           time.Time                       `json:"timestamp"`
+	Action              string                          `json:"action"`
+	Outcome             string                          `json:"outcome"`
+	Reason              string                          `json:"reason,omitempty"`
+	Login               string                          `json:"login,omitempty"`
+	InstallationId      int64                           `json:"installationId,omitempty"`
+	Issuer              string                          `json:"issuer,omitempty"`
+	Subject             string                          `json:"subject,omitempty"`
+	TokenId             string                          `json:"tokenId,omitempty"`
+	Repository          string                          `json:"repository,omitempty"`
+	RepositoryOwner     string                          `json:"repositoryOwner,omitempty"`
+	Actor               string                          `json:"actor,omitempty"`
+	Workflow            string                          `json:"workflow,omitempty"`
+	Ref                 string                          `json:"ref,omitempty"`
+	EventName           string                          `json:"eventName,omitempty"`
+	RunId               string                          `json:"runId,omitempty"`
+	RunAttempt          string                          `json:"runAttempt,omitempty"`
+	MatchedEntitlements []string                        `json:"matchedEntitlements,omitempty"`
+	Repositories        []string                        `json:"repositories,omitempty"`
+	Permissions         *github.InstallationPermissions `json:"permissions,omitempty"`
+	TokenExpiresAt      *time.Time                      `json:"tokenExpiresAt,omitempty"`
+	ClientIP            string                          `json:"clientIp,omitempty"`
+	ForwardedFor        string                          `json:"forwardedFor,omitempty"`
+}
+
+func NewAuditEvent(action string, req *http.Request) *AuditEvent {
+	event := &AuditEvent{Timestamp: time.Now().UTC(), Action: action}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		event.ClientIP = host
+	} else {
+		event.ClientIP = req.RemoteAddr
+	}
+	// Only informative as it is set by the client or by a proxy
+	event.ForwardedFor = req.Header.Get("X-Forwarded-For")
+	return event
+}
+
+/*
+ * Record who is requesting the token, once the OIDC token has been validated
+ */
+func (event *AuditEvent) setClaims(claims jwt.MapClaims) {
+	claim := func(name string) string {
+		values := claimValues(claims, name)
+		if len(values) == 0 {
+			return ""
+		}
+		return strings.Join(values, ",")
+	}
+	event.Issuer = claim("iss")
+	event.Subject = claim("sub")
+	event.TokenId = claim("jti")
+	event.Repository = claim("repository")
+	event.RepositoryOwner = claim("repository_owner")
+	event.Actor = claim("actor")
+	event.Workflow = claim("workflow_ref")
+	event.Ref = claim("ref")
+	event.EventName = claim("event_name")
+	event.RunId = claim("run_id")
+	event.RunAttempt = claim("run_attempt")
+}
+
+func (event *AuditEvent) setScope(scope *Scope) {
+	if scope == nil || scope.isEmpty() {
+		return
+	}
+	event.Repositories = scope.Repositories
+	event.Permissions = &scope.Permissions
+}
+
+func (event *AuditEvent) setOutcome(outcome string, reason string) {
+	event.Outcome = outcome
+	event.Reason = reason
+}
+
+/*
+ * Destination of the audit events, e.g. stdout, a file or a SIEM webhook
+ */
+type AuditSink interface {
+	Write(event *AuditEvent) error
+}
+
+/*
+ * Write the audit events as JSON lines, to stdout or to a file
+ */
+type WriterAuditSink struct {
+	writer io.Writer
+	mu     sync.Mutex
+}
+
+func NewWriterAuditSink(writer io.Writer) *WriterAuditSink {
+	return &WriterAuditSink{writer: writer}
+}
+
+func (sink *WriterAuditSink) Write(event *AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	_, err = sink.writer.Write(line)
+	return err
+}
+
+// Number of events waiting to be posted before new events are dropped
+const webhookAuditQueueSize = 1000
+
+/*
+ * Post each audit event as JSON to a webhook. Events are posted in the background so that a slow
+ * webhook doesn't delay the token requests.
+ */
+type WebhookAuditSink struct {
+	url    string
+	client *http.Client
+	events chan *AuditEvent
+}
+
+func NewWebhookAuditSink(url string) *WebhookAuditSink {
+	sink := &WebhookAuditSink{url: url, client: &http.Client{Timeout: 10 * time.Second}, events: make(chan *AuditEvent, webhookAuditQueueSize)}
+	go func() {
+		for event := range sink.events {
+			if err := sink.post(event); err != nil {
+				log.Println("failed to post audit event:", err)
+			}
+		}
+	}()
+	return sink
+}
+
+func (sink *WebhookAuditSink) Write(event *AuditEvent) error {
+	select {
+	case sink.events <- event:
+		return nil
+	default:
+		return fmt.Errorf("audit webhook queue is full, dropping event")
+	}
+}
+
+func (sink *WebhookAuditSink) post(event *AuditEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := sink.client.Post(sink.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("audit webhook %s returned status %d", sink.url, resp.StatusCode)
+	}
+	return nil
+}
+
+/*
+ * Create the sink matching the destination: "stdout", "file:<path>" or an http(s) URL
+ */
+func newAuditSink(destination string) (AuditSink, error) {
+	switch {
+	case destination == "" || destination == "stdout":
+		return NewWriterAuditSink(os.Stdout), nil
+	case strings.HasPrefix(destination, "file:"):
+		file, err := os.OpenFile(strings.TrimPrefix(destination, "file:"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		return NewWriterAuditSink(file), nil
+	case strings.HasPrefix(destination, "https://") || strings.HasPrefix(destination, "http://"):
+		return NewWebhookAuditSink(destination), nil
+	default:
+		return nil, fmt.Errorf("unknown audit log destination '%s', expected stdout, file:<path> or an http(s) URL", destination)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func readAuditEvents(t *testing.T, content []byte) []AuditEvent {
+	events := []AuditEvent{}
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var event AuditEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Invalid audit line %s: %s", line, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestTokenRequestAudit(t *testing.T) {
+	issuer, privateKey := newTestIssuer(t, "github", gitHubIssuerURL)
+	var auditLog bytes.Buffer
+	context := AppContext{
+		authenticator:     issuer,
+		auditSink:         NewWriterAuditSink(&auditLog),
+		configCache:       NewConfigCache(),
+		installationCache: NewInstallationCache(),
+	}
+	config := NewEntitlementConfig("octodemo", 1, "https://github.com", "oidc_entitlements", "")
+	config.Entitlements = []Entitlement{{RepositoryOwner: "major-tom", Source: "entitlements.json[0]", Scopes: Scope{Repositories: []string{"starman"}}}}
+	context.configCache.SetConfig("octodemo", config)
+
+	oidcToken := signTestToken(t, privateKey, "github", jwt.MapClaims{"iss": gitHubIssuerURL, "repository_owner": "major-tom", "repository": "major-tom/starman", "run_id": "42", "actor": "ziggy"})
+	requests := []string{
+		`{"login": "octodemo", "oidcToken": "` + oidcToken + `"}`,
+		`{"login": "octodemo", "oidcToken": "not-a-token"}`,
+		`{"login": "other", "oidcToken": "` + oidcToken + `"}`,
+	}
+	for _, body := range requests {
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(body))
+		req.RemoteAddr = "192.0.2.1:1234"
+		context.handleTokenRequest(httptest.NewRecorder(), req)
+	}
+
+	events := readAuditEvents(t, auditLog.Bytes())
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 audit events, got %d", len(events))
+	}
+
+	expected := AuditEvent{
+		Timestamp:           events[0].Timestamp,
+		Action:              "token",
+		Outcome:             OutcomeNoInstallation,
+		Reason:              "no installation found",
+		Login:               "octodemo",
+		Issuer:              gitHubIssuerURL,
+		Repository:          "major-tom/starman",
+		RepositoryOwner:     "major-tom",
+		Actor:               "ziggy",
+		RunId:               "42",
+		MatchedEntitlements: []string{"entitlements.json[0]"},
+		ClientIP:            "192.0.2.1",
+	}
+	if !reflect.DeepEqual(expected, events[0]) {
+		expectedJson, _ := json.MarshalIndent(expected, "", "  ")
+		gotJson, _ := json.MarshalIndent(events[0], "", "  ")
+		t.Errorf("Expected audit event to be %s, but got %s", string(expectedJson), string(gotJson))
+	}
+	if events[1].Outcome != OutcomeInvalidOIDC || !strings.Contains(events[1].Reason, "malformed") {
+		t.Errorf("Expected invalid OIDC token to be audited, got %+v", events[1])
+	}
+	if events[2].Outcome != OutcomeNoConfig || events[2].Repository != "major-tom/starman" {
+		t.Errorf("Expected missing configuration to be audited, got %+v", events[2])
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := newAuditSink("file:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Write(&AuditEvent{Action: "token", Outcome: OutcomeIssued})
+	sink.Write(&AuditEvent{Action: "token", Outcome: OutcomeNoScope})
+
+	content, _ := os.ReadFile(path)
+	events := readAuditEvents(t, content)
+	if len(events) != 2 || events[1].Outcome != OutcomeNoScope {
+		t.Errorf("Expected 2 audit lines, got %s", content)
+	}
+}
+
+func TestWebhookAuditSink(t *testing.T) {
+	received := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		received <- body
+	}))
+	defer server.Close()
+
+	sink, err := newAuditSink(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Write(&AuditEvent{Action: "token", Outcome: OutcomeIssued, Login: "octodemo"})
+
+	select {
+	case body := <-received:
+		events := readAuditEvents(t, body)
+		if events[0].Login != "octodemo" {
+			t.Errorf("Unexpected audit event %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Audit event was not posted")
+	}
+
+	if _, err := newAuditSink("syslog"); err == nil {
+		t.Error("Expected unknown destination to be rejected")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+/*
+ * Expose a go-billy file system, e.g. the in-memory worktree of a clone, as an io/fs file system
+ */
+type billyFS struct {
+	filesystem billy.Filesystem
+}
+
+func (b billyFS) Open(name string) (fs.File, error) {
+	info, err := b.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := b.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &billyDir{info: info, entries: entries}, nil
+	}
+
+	file, err := b.filesystem.Open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return billyFile{file, info}, nil
+}
+
+func (b billyFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := b.filesystem.Stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+func (b billyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	infos, err := b.filesystem.ReadDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+type billyFile struct {
+	billy.File
+	info fs.FileInfo
+}
+
+func (f billyFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Directories can be listed but not read
+type billyDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+}
+
+func (d *billyDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *billyDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+
+func (d *billyDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+func (d *billyDir) Close() error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func TestBillyFS(t *testing.T) {
+	filesystem := memfs.New()
+	util.WriteFile(filesystem, "generic.json", []byte("{}"), 0644)
+	util.WriteFile(filesystem, "owner/major-tom/test-repo.json", []byte("{}"), 0644)
+
+	fsys := billyFS{filesystem}
+
+	// memfs doesn't keep the modification time of the files, so fstest.TestFS can't be used
+	files := []string{}
+	err := fs.WalkDir(fsys, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFiles := []string{".", "generic.json", "owner", "owner/major-tom", "owner/major-tom/test-repo.json"}
+	if !reflect.DeepEqual(expectedFiles, files) {
+		t.Errorf("Expected the walk to list %v, but got %v", expectedFiles, files)
+	}
+
+	content, err := fs.ReadFile(fsys, "owner/major-tom/test-repo.json")
+	if err != nil || string(content) != "{}" {
+		t.Errorf("Expected to read the file, but got %q, %v", content, err)
+	}
+	if _, err := fs.ReadFile(fsys, "owner"); err == nil {
+		t.Error("Expected a folder not to be readable")
+	}
+	if _, err := fs.Stat(fsys, "missing.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a missing file not to exist, but got %v", err)
+	}
+	if _, err := fs.Stat(fsys, "/generic.json"); err == nil {
+		t.Error("Expected an absolute path to be rejected")
+	}
+}
+
+func TestLoadFolderFromMemory(t *testing.T) {
+	filesystem := memfs.New()
+	util.WriteFile(filesystem, "generic.json", []byte(`{"repository_owner": "major-tom", "scopes": {"permissions": {"issues": "read"}}}`), 0644)
+	util.WriteFile(filesystem, "owner/ziggy/stardust.json", []byte(`{"scopes": {"permissions": {"contents": "read"}}}`), 0644)
+	util.WriteFile(filesystem, "owner/ignored.json", []byte(`{"scopes": {"permissions": {"contents": "write"}}}`), 0644)
+
+	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
+	if err := config.loadRepository(billyFS{filesystem}); err != nil {
+		t.Fatal(err)
+	}
+
+	sources := []string{}
+	for _, entitlement := range config.Entitlements {
+		sources = append(sources, entitlement.Source)
+	}
+	if len(sources) != 2 || sources[0] != "generic.json" || sources[1] != "owner/ziggy/stardust.json" {
+		t.Errorf("Expected the entitlements of generic.json and owner/ziggy/stardust.json, but got %v", sources)
+	}
+	if config.Entitlements[1].RepositoryOwner != "ziggy" {
+		t.Errorf("Expected the owner folder to set the repository owner, but got %s", config.Entitlements[1].RepositoryOwner)
+	}
+	if len(config.Issues) != 1 || config.Issues[0].Source != "owner/ignored.json" {
+		t.Errorf("Expected the file stored directly in the owner folder to be reported, but got %v", config.Issues)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Prefix used in an entitlement value to opt into regular expression matching, e.g. "regex:^refs/heads/release-[0-9]+$"
+const regexPrefix = "regex:"
+
+/*
+ * A claimMatcher checks the value of a single claim against the value set in an entitlement.
+ * The value of the entitlement can be:
+ * - an exact value, e.g. "refs/heads/main". Any special character is matched literally.
+ * - a glob, e.g. "refs/heads/release/*". '*' matches any sequence of characters but '/', '**' matches any sequence of characters
+ *   and '?' matches any single character but '/'.
+ * - a regular expression prefixed with "regex:", e.g. "regex:^refs/heads/release-[0-9]+$". The regex is not anchored by default.
+ */
+type claimMatcher struct {
+	Claim   string
+	Pattern string
+}
+
+func newClaimMatcher(claim string, pattern string) claimMatcher {
+	return claimMatcher{Claim: claim, Pattern: pattern}
+}
+
+/*
+ * Build the regular expression equivalent to the pattern
+ */
+func (matcher claimMatcher) compile() (*regexp.Regexp, error) {
+	if strings.HasPrefix(matcher.Pattern, regexPrefix) {
+		return regexp.Compile(strings.TrimPrefix(matcher.Pattern, regexPrefix))
+	}
+	if matcher.isGlob() {
+		return regexp.Compile(globToRegexString(matcher.Pattern))
+	}
+	return regexp.Compile("^" + regexp.QuoteMeta(matcher.Pattern) + "$")
+}
+
+func (matcher claimMatcher) isExact() bool {
+	return !strings.HasPrefix(matcher.Pattern, regexPrefix) && !matcher.isGlob()
+}
+
+func (matcher claimMatcher) isGlob() bool {
+	return !strings.HasPrefix(matcher.Pattern, regexPrefix) && strings.ContainsAny(matcher.Pattern, "*?")
+}
+
+/*
+ * Check the claim value against the pattern. A claim which is not set never matches.
+ * When the claim is a list (e.g. aud), matching any of its values is enough.
+ */
+func (matcher claimMatcher) matches(claims jwt.MapClaims) bool {
+	values := claimValues(claims, matcher.Claim)
+	if len(values) == 0 {
+		return false
+	}
+
+	var regex *regexp.Regexp
+	if !matcher.isExact() {
+		var err error
+		regex, err = matcher.compile()
+		if err != nil {
+			return false
+		}
+	}
+
+	for _, value := range values {
+		// Exact match doesn't need to go through a regex
+		if (regex == nil && value == matcher.Pattern) || (regex != nil && regex.MatchString(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (matcher claimMatcher) String() string {
+	return fmt.Sprintf("%s:%s", matcher.Claim, matcher.Pattern)
+}
+
+/*
+ * Convert a glob to an anchored regex string
+ */
+func globToRegexString(glob string) string {
+	var builder strings.Builder
+	builder.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				builder.WriteString(".*")
+				i++
+			} else {
+				builder.WriteString("[^/]*")
+			}
+		case '?':
+			builder.WriteString("[^/]")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(glob[i])))
+		}
+	}
+	builder.WriteString("$")
+	return builder.String()
+}
+
+/*
+ * Get the values of a claim as strings. Numeric values are formatted without exponent so that ids can be compared.
+ */
+func claimValues(claims jwt.MapClaims, claim string) []string {
+	value, ok := claims[claim]
+	if !ok || value == nil {
+		return nil
+	}
+
+	switch typedValue := value.(type) {
+	case []interface{}:
+		values := []string{}
+		for _, item := range typedValue {
+			values = append(values, claimValueString(item))
+		}
+		return values
+	case []string:
+		return typedValue
+	default:
+		return []string{claimValueString(value)}
+	}
+}
+
+func claimValueString(value interface{}) string {
+	switch typedValue := value.(type) {
+	case string:
+		return typedValue
+	case float64:
+		return strconv.FormatFloat(typedValue, 'f', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
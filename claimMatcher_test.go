@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestExactClaimMatcher(t *testing.T) {
+	claims := jwt.MapClaims{
+		"ref":      "refs/heads/release+1(beta)",
+		"workflow": "Manual Test Workflow",
+	}
+
+	if !newClaimMatcher("ref", "refs/heads/release+1(beta)").matches(claims) {
+		t.Error("Expected special characters to be matched literally")
+	}
+	if newClaimMatcher("workflow", "Manual.*").matches(claims) {
+		t.Error("Expected exact matcher to not be interpreted as a regex")
+	}
+	if newClaimMatcher("workflow", "Manual Test").matches(claims) {
+		t.Error("Expected exact matcher to match the whole value")
+	}
+	if newClaimMatcher("environment", "production").matches(claims) {
+		t.Error("Expected a claim which is not set to not match")
+	}
+}
+
+func TestGlobClaimMatcher(t *testing.T) {
+	claims := jwt.MapClaims{
+		"ref":              "refs/heads/release/1.0",
+		"job_workflow_ref": "major-tom/starman/.github/workflows/deploy.yml@refs/heads/main",
+	}
+
+	matchingPatterns := []string{"refs/heads/release/*", "refs/heads/**", "refs/heads/release/?.?", "**/1.0"}
+	for _, pattern := range matchingPatterns {
+		if !newClaimMatcher("ref", pattern).matches(claims) {
+			t.Errorf("Expected %s to match", pattern)
+		}
+	}
+
+	notMatchingPatterns := []string{"refs/heads/*", "refs/heads/release/?", "refs/tags/**", "release/*"}
+	for _, pattern := range notMatchingPatterns {
+		if newClaimMatcher("ref", pattern).matches(claims) {
+			t.Errorf("Expected %s to not match", pattern)
+		}
+	}
+
+	if !newClaimMatcher("job_workflow_ref", "major-tom/starman/.github/workflows/*.yml@refs/heads/main").matches(claims) {
+		t.Error("Expected glob to match the workflow file name")
+	}
+}
+
+func TestRegexClaimMatcher(t *testing.T) {
+	claims := jwt.MapClaims{
+		"ref": "refs/heads/release-42",
+	}
+
+	if !newClaimMatcher("ref", "regex:^refs/heads/release-[0-9]+$").matches(claims) {
+		t.Error("Expected regex to match")
+	}
+	if !newClaimMatcher("ref", "regex:release").matches(claims) {
+		t.Error("Expected unanchored regex to match a part of the value")
+	}
+	if newClaimMatcher("ref", "regex:^release").matches(claims) {
+		t.Error("Expected anchored regex to not match")
+	}
+	if newClaimMatcher("ref", "regex:(").matches(claims) {
+		t.Error("Expected invalid regex to not match")
+	}
+}
+
+func TestNumericClaimMatcher(t *testing.T) {
+	claims := jwt.MapClaims{
+		"repository_id":       float64(630836305),
+		"repository_owner_id": "2787414",
+		"run_id":              4779904167,
+	}
+
+	if !newClaimMatcher("repository_id", "630836305").matches(claims) {
+		t.Error("Expected float claim to match")
+	}
+	if !newClaimMatcher("repository_owner_id", "2787414").matches(claims) {
+		t.Error("Expected string claim to match")
+	}
+	if !newClaimMatcher("run_id", "4779904167").matches(claims) {
+		t.Error("Expected int claim to match")
+	}
+}
+
+func TestListClaimMatcher(t *testing.T) {
+	claims := jwt.MapClaims{
+		"aud": []interface{}{"https://github.com/major-tom", "api://ActionsOIDCGateway"},
+	}
+
+	if !newClaimMatcher("aud", "api://ActionsOIDCGateway").matches(claims) {
+		t.Error("Expected one of the audiences to match")
+	}
+	if newClaimMatcher("aud", "api://Other").matches(claims) {
+		t.Error("Expected no audience to match")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type SimulationResult struct {
+	MatchedEntitlements []EntitlementExplanation `json:"matchedEntitlements"`
+	Scope               *Scope                   `json:"scope"`
+	// Why no token would be delivered regardless of the entitlements, e.g. when the policy doesn't allow the repository owner
+	Message string `json:"message,omitempty"`
+}
+
+/*
+ * Validate an entitlement configuration stored in a folder (repository mode) or a file (single file mode)
+ * Usage: github-oidc-auth-app validate <dir|file>
+ */
+func runValidateCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: github-oidc-auth-app validate <dir|file>")
+		return 2
+	}
+	path := args[0]
+
+	issues := validateConfig(path)
+	for _, issue := range issues {
+		fmt.Println(issue.String())
+	}
+
+	config, err := loadLocalConfig(path)
+	if err != nil {
+		fmt.Printf("failed to load configuration from %s: %s\n", path, err)
+		return 1
+	}
+
+	if len(issues) > 0 {
+		fmt.Printf("%d issue(s) found, %d entitlement(s) loaded from %s\n", len(issues), len(config.Entitlements), path)
+		return 1
+	}
+	fmt.Printf("no issue found, %d entitlement(s) loaded from %s\n", len(config.Entitlements), path)
+	return 0
+}
+
+/*
+ * Compute the scope a set of claims would get from an entitlement configuration stored in a folder or a file.
+ * The claims are expected to come from the GitHub issuer set by GHES_URL or ISSUER_URL, as for the app.
+ * Usage: github-oidc-auth-app simulate <dir|file> <claims.json>
+ */
+func runSimulateCommand(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: github-oidc-auth-app simulate <dir|file> <claims.json>")
+		return 2
+	}
+
+	result, err := simulate(args[0], args[1], gitHubIssuerFromEnv())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	output, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(output))
+	return 0
+}
+
+func simulate(configPath string, claimsPath string, gitHubIssuer string) (SimulationResult, error) {
+	result := SimulationResult{MatchedEntitlements: []EntitlementExplanation{}}
+
+	config, err := loadLocalConfig(configPath)
+	if err != nil {
+		return result, fmt.Errorf("failed to load configuration from %s: %w", configPath, err)
+	}
+	config.GitHubIssuer = gitHubIssuer
+
+	claimsContent, err := os.ReadFile(claimsPath)
+	if err != nil {
+		return result, fmt.Errorf("failed to read claims from %s: %w", claimsPath, err)
+	}
+	var claims jwt.MapClaims
+	err = json.Unmarshal(claimsContent, &claims)
+	if err != nil {
+		return result, fmt.Errorf("failed to parse claims from %s: %w", claimsPath, err)
+	}
+
+	// The policy is checked before the entitlements, as the /token endpoint does
+	if !config.allows(claims) {
+		result.Scope = NewScope()
+		result.Message = fmt.Sprintf("repository owner %v is not allowed to request tokens by the policy", claims["repository_owner"])
+		return result, nil
+	}
+
+	for _, explanation := range config.explain(claims, true) {
+		if explanation.Matched {
+			result.MatchedEntitlements = append(result.MatchedEntitlements, explanation)
+		}
+	}
+	result.Scope = config.computeScopes(claims)
+	return result, nil
+}
+
+/*
+ * Load an entitlement configuration from the local disk, either from a folder or from a single file
+ */
+func loadLocalConfig(path string) (*EntitlementConfig, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		config := NewEntitlementConfig("local", 0, "", path, "")
+		fsys := os.DirFS(path)
+		err = config.loadFolder(fsys, ".", true)
+		if err == nil {
+			err = config.loadPolicyFile(fsys, policyFileName)
+		}
+
+		// Report the files with the path they were given with
+		for index := range config.Entitlements {
+			config.Entitlements[index].Source = filepath.Join(path, config.Entitlements[index].Source)
+		}
+		for index := range config.Quarantined {
+			config.Quarantined[index].Source = filepath.Join(path, config.Quarantined[index].Source)
+		}
+		return config, err
+	}
+
+	config := NewEntitlementConfig("local", 0, "", "", path)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = config.parseConfigFile(content)
+	return config, err
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/v53/github"
+)
+
+func TestSimulateRepoConfig(t *testing.T) {
+	result, err := simulate("test/deep-env-repo", "test/claims.json", gitHubIssuerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := "write"
+	expectedScopes := Scope{
+		Repositories: []string{"codespace-oddity"},
+		Permissions: github.InstallationPermissions{
+			Contents: &write,
+		},
+	}
+	expectedResult := SimulationResult{
+		MatchedEntitlements: []EntitlementExplanation{
+			{
+				Source:  "test/deep-env-repo/repositories/codespace-oddity/owner/major-tom/repository/starman/environment/production/test-workflow.json",
+				Effect:  "allow",
+				Matched: true,
+				Scopes:  expectedScopes,
+			},
+		},
+		Scope: &expectedScopes,
+	}
+
+	if !reflect.DeepEqual(expectedResult, result) {
+		expectedJson, _ := json.MarshalIndent(expectedResult, "", "  ")
+		gotJson, _ := json.MarshalIndent(result, "", "  ")
+		t.Errorf("Expected simulation result to be %s, but got %s", string(expectedJson), string(gotJson))
+	}
+}
+
+func TestSimulateFileConfig(t *testing.T) {
+	result, err := simulate("test/no-match.json", "test/claims.json", gitHubIssuerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.MatchedEntitlements) != 0 {
+		t.Errorf("Expected no matching entitlement, but got %v", result.MatchedEntitlements)
+	}
+	if !result.Scope.isEmpty() {
+		t.Errorf("Expected scope to be empty, but got %s", result.Scope.String())
+	}
+}
+
+func TestSimulateGHESClaims(t *testing.T) {
+	// The claims of a token delivered by a GHES instance
+	var claims map[string]interface{}
+	content, _ := os.ReadFile("test/claims.json")
+	json.Unmarshal(content, &claims)
+	claims["iss"] = "https://ghes.example.com/_services/token"
+	content, _ = json.Marshal(claims)
+	claimsPath := filepath.Join(t.TempDir(), "claims.json")
+	os.WriteFile(claimsPath, content, 0600)
+
+	t.Setenv("GHES_URL", "https://ghes.example.com")
+	result, err := simulate("test/deep-env-repo", claimsPath, gitHubIssuerFromEnv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Scope.isEmpty() {
+		t.Error("Expected the entitlements without iss to match the claims of the GHES issuer")
+	}
+
+	// The same claims don't come from GitHub Actions on github.com
+	result, err = simulate("test/deep-env-repo", claimsPath, gitHubIssuerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Scope.isEmpty() {
+		t.Errorf("Expected the claims of another issuer not to match, but got %s", result.Scope.String())
+	}
+}
+
+func TestSimulateMissingClaims(t *testing.T) {
+	_, err := simulate("test/no-match.json", "test/does-not-exist.json", gitHubIssuerURL)
+	if err == nil {
+		t.Error("Expected simulation to fail when the claims file is missing")
+	}
+}
+
+func TestSimulatePolicy(t *testing.T) {
+	result, err := simulate("test/policy-repo", "test/claims.json", gitHubIssuerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Message != "" || result.Scope.isEmpty() {
+		t.Errorf("Expected the allowed repository owner to get a scope, but got %s: %s", result.Scope.String(), result.Message)
+	}
+
+	// The claims of a repository owner the policy doesn't allow
+	var claims map[string]interface{}
+	content, _ := os.ReadFile("test/claims.json")
+	json.Unmarshal(content, &claims)
+	claims["repository_owner_id"] = "999"
+	content, _ = json.Marshal(claims)
+	claimsPath := filepath.Join(t.TempDir(), "claims.json")
+	os.WriteFile(claimsPath, content, 0600)
+
+	result, err = simulate("test/policy-repo", claimsPath, gitHubIssuerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Message == "" || !result.Scope.isEmpty() || len(result.MatchedEntitlements) != 0 {
+		t.Errorf("Expected the policy to deny the repository owner, but got %s with %d entitlement(s)", result.Scope.String(), len(result.MatchedEntitlements))
+	}
+}
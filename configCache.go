@@ -1,6 +1,7 @@
 package main
 
 import (
+	"sort"
 	"strings"
 	"sync"
 )
@@ -26,8 +27,50 @@ func (configCache *ConfigCache) SetConfig(login string, config *EntitlementConfi
 	configCache.cache[strings.ToUpper(login)] = config
 }
 
+/*
+ * Swap a configuration which was just loaded into the cache, returning the configuration in use.
+ * When it failed to load, the last configuration which loaded is kept in use, flagged with the error, so that a broken change doesn't revoke every token of the installation.
+ * A configuration which failed to load is only cached when there is nothing to fall back to.
+ * A configuration which loaded fully validates: an invalid file or entitlement, or a file under an organization permission folder without
+ * a permission level folder, fails the load. The only issues left are about the files stored directly in a semantic folder, which the loader skips.
+ */
+func (configCache *ConfigCache) SwapConfig(login string, config *EntitlementConfig) *EntitlementConfig {
+	configCache.mu.Lock()
+	defer configCache.mu.Unlock()
+
+	previous := configCache.cache[strings.ToUpper(login)]
+	if config.LoadError == "" || previous == nil || previous.LoadError != "" {
+		configCache.cache[strings.ToUpper(login)] = config
+		return config
+	}
+
+	// The previous configuration is copied rather than modified as requests could be reading it
+	fallback := *previous
+	fallback.ReloadError = config.LoadError
+	configCache.cache[strings.ToUpper(login)] = &fallback
+	config.Fallback = &fallback
+	return &fallback
+}
+
 func (configCache *ConfigCache) DeleteConfig(login string) {
 	configCache.mu.Lock()
 	defer configCache.mu.Unlock()
 	delete(configCache.cache, strings.ToUpper(login))
 }
+
+/*
+ * Get all the cached configurations, sorted by login
+ */
+func (configCache *ConfigCache) GetConfigs() []*EntitlementConfig {
+	configCache.mu.Lock()
+	defer configCache.mu.Unlock()
+
+	configs := make([]*EntitlementConfig, 0, len(configCache.cache))
+	for _, config := range configCache.cache {
+		configs = append(configs, config)
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		return strings.ToUpper(configs[i].Login) < strings.ToUpper(configs[j].Login)
+	})
+	return configs
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/google/go-github/v53/github"
+)
+
+// Push events list up to 20 commits, the files changed by the other commits are unknown
+const maxPushEventCommits = 20
+
+/*
+ * Files changed in the config repository since a previously loaded configuration, so that only these files are parsed again
+ */
+type ConfigChanges struct {
+	// Configuration loaded from the commit preceding the changes
+	Base *EntitlementConfig
+	// Commit the changes lead to
+	After string
+	// Paths of the added, modified and removed files, relative to the root of the repository
+	Paths []string
+}
+
+/*
+ * Get the paths of the files added, modified or removed by the commits of a push event
+ */
+func pushedPaths(event github.PushEvent) []string {
+	paths := map[string]bool{}
+	for _, commit := range event.Commits {
+		for _, files := range [][]string{commit.Added, commit.Removed, commit.Modified} {
+			for _, file := range files {
+				paths[file] = true
+			}
+		}
+	}
+
+	sortedPaths := make([]string, 0, len(paths))
+	for path := range paths {
+		sortedPaths = append(sortedPaths, path)
+	}
+	sort.Strings(sortedPaths)
+	return sortedPaths
+}
+
+/*
+ * In-memory clone of a config repository. The clones are kept across reloads, a clone can only be updated by one reload at a time.
+ */
+type ConfigClone struct {
+	mu         sync.Mutex
+	repository *git.Repository
+}
+
+// Clones by repository URL
+var configClones sync.Map
+
+func getConfigClone(url string) *ConfigClone {
+	clone, _ := configClones.LoadOrStore(url, &ConfigClone{})
+	return clone.(*ConfigClone)
+}
+
+func dropConfigClone(url string) {
+	configClones.Delete(url)
+}
+
+/*
+ * Bring the clone of the config repository to the latest commit of the ref, cloning the repository when there is no usable clone yet.
+ * Both the git objects and the worktree are kept in memory, nothing is written to disk.
+ */
+func (config *EntitlementConfig) cloneOrFetch(clone *ConfigClone, url string, auth transport.AuthMethod) (*git.Repository, error) {
+	if clone.repository != nil {
+		err := config.fetch(clone.repository, auth)
+		if err == nil {
+			return clone.repository, nil
+		}
+		log.Printf("couldn't update the clone of %s, cloning it again: %s", url, err)
+		clone.repository = nil
+	}
+
+	repository, err := git.Clone(memory.NewStorage(), memfs.New(), &git.CloneOptions{
+		URL:      url,
+		Auth:     auth,
+		Progress: os.Stdout,
+		// The remote HEAD, i.e. the default branch, is cloned when no ref is set
+		ReferenceName: plumbing.ReferenceName(config.Ref),
+		SingleBranch:  true,
+		// Only the latest commit is loaded, the history would be kept in memory for nothing
+		Depth: 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	clone.repository = repository
+	return repository, nil
+}
+
+/*
+ * Fetch the ref of the configuration and check out its latest commit. The clone needs to be on the same ref.
+ */
+func (config *EntitlementConfig) fetch(repository *git.Repository, auth transport.AuthMethod) error {
+	remote, err := repository.Remote("origin")
+	if err != nil {
+		return err
+	}
+
+	ref := plumbing.ReferenceName(config.Ref)
+	if ref == "" {
+		// The default branch could have changed since the repository was cloned
+		remoteRefs, err := remote.List(&git.ListOptions{Auth: auth})
+		if err != nil {
+			return err
+		}
+		for _, remoteRef := range remoteRefs {
+			if remoteRef.Name() == plumbing.HEAD && remoteRef.Type() == plumbing.SymbolicReference {
+				ref = remoteRef.Target()
+			}
+		}
+		if ref == "" {
+			return fmt.Errorf("couldn't find the default branch")
+		}
+	}
+
+	// A branch is checked out, while a tag leaves HEAD detached
+	var target plumbing.ReferenceName
+	head, err := repository.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return err
+	}
+	if ref.IsBranch() {
+		if head.Type() != plumbing.SymbolicReference || head.Target() != ref {
+			return fmt.Errorf("the clone is not on %s", ref)
+		}
+		target = plumbing.NewRemoteReferenceName("origin", ref.Short())
+	} else if ref.IsTag() {
+		if head.Type() == plumbing.SymbolicReference {
+			return fmt.Errorf("the clone is not on %s", ref)
+		}
+		target = ref
+	} else {
+		return fmt.Errorf("%s is neither a branch nor a tag", ref)
+	}
+
+	err = remote.Fetch(&git.FetchOptions{
+		Auth:     auth,
+		RefSpecs: []gitconfig.RefSpec{gitconfig.RefSpec(fmt.Sprintf("+%s:%s", ref, target))},
+		Tags:     git.NoTags,
+		Force:    true,
+		Depth:    1,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return err
+	}
+
+	commit, err := repository.Reference(target, true)
+	if err != nil {
+		return err
+	}
+	worktree, err := repository.Worktree()
+	if err != nil {
+		return err
+	}
+	return worktree.Reset(&git.ResetOptions{Commit: commit.Hash(), Mode: git.HardReset})
+}
+
+/*
+ * Parse the changed files of the clone on top of the configuration they changed, the entitlements of the other files are kept as they are
+ */
+func (config *EntitlementConfig) loadRepositoryChanges(fsys fs.FS, changes *ConfigChanges) error {
+	changed := map[string]bool{}
+	for _, file := range changes.Paths {
+		changed[file] = true
+	}
+
+	for _, entitlement := range changes.Base.Entitlements {
+		if !changed[entitlement.Source] {
+			config.Entitlements = append(config.Entitlements, entitlement)
+		}
+	}
+	for _, entitlement := range changes.Base.Quarantined {
+		if !changed[entitlement.Source] {
+			config.Quarantined = append(config.Quarantined, entitlement)
+		}
+	}
+	config.Issues = []ValidationIssue{}
+	for _, issue := range changes.Base.Issues {
+		if !changed[issue.Source] {
+			config.Issues = append(config.Issues, issue)
+		}
+	}
+	if !changed[policyFileName] {
+		config.Policy = changes.Base.Policy
+	}
+
+	for _, file := range changes.Paths {
+		if !strings.HasSuffix(file, ".json") {
+			continue
+		}
+		if _, err := fs.Stat(fsys, file); errors.Is(err, fs.ErrNotExist) {
+			// The file was removed, or added then removed
+			continue
+		}
+
+		config.Issues = append(config.Issues, validateFolderFile(fsys, ".", file)...)
+
+		if file == policyFileName {
+			if err := config.loadPolicyFile(fsys, file); err != nil {
+				return err
+			}
+			continue
+		}
+		if isIgnoredFolder(path.Dir(file)) {
+			continue
+		}
+		if err := config.loadEntitlementFile(fsys, file, !strings.Contains(file, "/")); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("reloaded %d changed file(s) for org %s", len(changes.Paths), config.Login)
+	return nil
+}
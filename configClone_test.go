@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-github/v53/github"
+)
+
+func copyTestFolder(t *testing.T, source string, destination string) {
+	err := filepath.WalkDir(source, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, _ := filepath.Rel(source, path)
+		if entry.IsDir() {
+			return os.MkdirAll(filepath.Join(destination, relativePath), 0755)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(destination, relativePath), content, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sortedEntitlements(entitlements []Entitlement) []Entitlement {
+	sorted := append([]Entitlement{}, entitlements...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Source < sorted[j].Source
+	})
+	return sorted
+}
+
+func TestLoadRepositoryChanges(t *testing.T) {
+	root := t.TempDir()
+	copyTestFolder(t, "test/good-repo", root)
+
+	base := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
+	if err := base.loadRepository(os.DirFS(root)); err != nil {
+		t.Fatal(err)
+	}
+	baseSources := []string{}
+	for _, entitlement := range base.Entitlements {
+		baseSources = append(baseSources, entitlement.Source)
+	}
+
+	// Remove a file, modify another one and add a policy and an entitlement
+	os.Remove(filepath.Join(root, "repositories/codespace-oddity/owner/major-tom/test-repo-dev.json"))
+	os.WriteFile(filepath.Join(root, "generic.json"), []byte(`{"repository_owner": "major-tom", "scopes": {"permissions": {"issues": "read"}}}`), 0644)
+	os.WriteFile(filepath.Join(root, "policy.json"), []byte(`{"allowed_repository_owner_ids": [42]}`), 0644)
+	os.MkdirAll(filepath.Join(root, "owner/ziggy"), 0755)
+	os.WriteFile(filepath.Join(root, "owner/ziggy/stardust.json"), []byte(`{"scopes": {"permissions": {"contents": "read"}}}`), 0644)
+
+	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
+	changes := &ConfigChanges{
+		Base:  base,
+		Paths: []string{"README.md", "generic.json", "owner/ziggy/stardust.json", "policy.json", "repositories/codespace-oddity/owner/major-tom/test-repo-dev.json"},
+	}
+	if err := config.loadRepositoryChanges(os.DirFS(root), changes); err != nil {
+		t.Fatal(err)
+	}
+
+	// The result needs to be the same as loading the whole repository again
+	expectedConfig := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
+	if err := expectedConfig.loadRepository(os.DirFS(root)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(sortedEntitlements(expectedConfig.Entitlements), sortedEntitlements(config.Entitlements)) {
+		expectedJson, _ := json.MarshalIndent(sortedEntitlements(expectedConfig.Entitlements), "", "  ")
+		gotJson, _ := json.MarshalIndent(sortedEntitlements(config.Entitlements), "", "  ")
+		t.Errorf("Expected entitlements to be %s, but got %s", string(expectedJson), string(gotJson))
+	}
+	if !reflect.DeepEqual(expectedConfig.Policy, config.Policy) {
+		t.Errorf("Expected policy to be %v, but got %v", expectedConfig.Policy, config.Policy)
+	}
+	for index, entitlement := range base.Entitlements {
+		if entitlement.Source != baseSources[index] {
+			t.Errorf("Expected the base configuration to be left untouched, but got %s instead of %s", entitlement.Source, baseSources[index])
+		}
+	}
+}
+
+func commitTestFile(t *testing.T, repository *git.Repository, name string, content string) plumbing.Hash {
+	worktree, err := repository.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(worktree.Filesystem.Root(), name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktree.Add(name); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := worktree.Commit("update "+name, &git.CommitOptions{Author: &object.Signature{Name: "Major Tom", Email: "major-tom@example.com", When: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestCloneOrFetch(t *testing.T) {
+	upstreamPath := t.TempDir()
+	upstream, err := git.PlainInit(upstreamPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitTestFile(t, upstream, "generic.json", "{}")
+
+	clone := &ConfigClone{}
+	config := NewEntitlementConfig("test", 1, "", "test", "")
+	repository, err := config.cloneOrFetch(clone, upstreamPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The existing clone is updated with the new commits
+	skippedHash := commitTestFile(t, upstream, "README.md", "# Entitlements")
+	hash := commitTestFile(t, upstream, "policy.json", `{"allowed_repository_owner_ids": [42]}`)
+	repository, err = config.cloneOrFetch(clone, upstreamPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repository.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Hash() != hash {
+		t.Errorf("Expected clone to be on %s, but got %s", hash, head.Hash())
+	}
+	worktree, err := repository.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(billyFS{worktree.Filesystem}, "policy.json"); err != nil {
+		t.Errorf("Expected the new file to be checked out: %s", err)
+	}
+	// The history isn't kept in memory
+	if _, err := repository.CommitObject(skippedHash); err == nil {
+		t.Error("Expected the commits preceding the latest one not to be fetched")
+	}
+
+	// The clone is replaced when it isn't on the configured ref
+	if _, err := upstream.CreateTag("v1", hash, nil); err != nil {
+		t.Fatal(err)
+	}
+	config.Ref = "refs/tags/v1"
+	repository, err = config.cloneOrFetch(clone, upstreamPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err = repository.Head()
+	if err != nil || head.Hash() != hash {
+		t.Errorf("Expected clone to be on tag v1 at %s, but got %v, %v", hash, head, err)
+	}
+
+	// New commits on the branch don't move the tag
+	commitTestFile(t, upstream, "generic.json", `{"repository_owner": "major-tom"}`)
+	repository, err = config.cloneOrFetch(clone, upstreamPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err = repository.Head()
+	if err != nil || head.Hash() != hash {
+		t.Errorf("Expected clone to stay on tag v1 at %s, but got %v, %v", hash, head, err)
+	}
+}
+
+func TestPushChanges(t *testing.T) {
+	context := NewAppContext(nil, "", ".github-private", "", "", "", nil, nil, nil, "https://github.com", gitHubIssuerURL, "")
+	base := NewEntitlementConfig("octodemo", 1, "https://github.com", ".github-private", "")
+	base.CommitSHA = "abc"
+	context.configCache.SetConfig("octodemo", base)
+
+	event := github.PushEvent{
+		Before: github.String("abc"),
+		After:  github.String("def"),
+		Repo: &github.PushEventRepository{
+			Name: github.String(".github-private"),
+			Owner: &github.User{
+				Login: github.String("octodemo"),
+			},
+		},
+		Commits: []*github.HeadCommit{
+			{Added: []string{"owner/ziggy/stardust.json"}, Modified: []string{"generic.json"}},
+			{Removed: []string{"generic.json"}},
+		},
+	}
+
+	changes := context.pushChanges(event)
+	if changes == nil || changes.Base != base || changes.After != "def" || !reflect.DeepEqual([]string{"generic.json", "owner/ziggy/stardust.json"}, changes.Paths) {
+		t.Errorf("Expected the changed paths to be listed, but got %+v", changes)
+	}
+
+	event.Forced = github.Bool(true)
+	if context.pushChanges(event) != nil {
+		t.Error("Expected a forced push to reload the whole configuration")
+	}
+
+	event.Forced = nil
+	event.Before = github.String("123")
+	if context.pushChanges(event) != nil {
+		t.Error("Expected a push from another commit than the cached one to reload the whole configuration")
+	}
+}
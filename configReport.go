@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/google/go-github/v53/github"
+)
+
+// Name of the check run, and context of the commit status, created on the config repository
+const configReportName = "OIDC entitlements"
+
+// The GitHub API accepts up to 50 annotations per request
+const maxReportAnnotations = 50
+
+// The GitHub API rejects check run summaries longer than 65535 characters and status descriptions longer than 140
+const maxReportSummaryLength = 65535
+const maxStatusDescriptionLength = 140
+
+/*
+ * Result of a configuration reload, as reported on the commit of the config repository which triggered it
+ */
+type ConfigReport struct {
+	// success, neutral when there are warnings, failure when the configuration failed to load
+	Conclusion  string
+	Title       string
+	Summary     string
+	Annotations []*github.CheckRunAnnotation
+}
+
+type reportFinding struct {
+	level   string
+	source  string
+	line    int
+	message string
+}
+
+/*
+ * Get the path of the file an entitlement or an issue comes from, without the index of the entitlement in single file mode
+ */
+func reportPath(source string) string {
+	return regexp.MustCompile(`\[\d+\]$`).ReplaceAllString(source, "")
+}
+
+/*
+ * Summarize the outcome of the load of a configuration: the number of entitlements, the validation errors and the rules which are overly broad
+ */
+func buildConfigReport(config *EntitlementConfig) ConfigReport {
+	findings := []reportFinding{}
+
+	// Validation issues prevent the load, except the ones about files which are ignored
+	issueLevel := "warning"
+	if config.LoadError != "" {
+		issueLevel = "failure"
+	}
+	for _, issue := range config.Issues {
+		findings = append(findings, reportFinding{issueLevel, issue.Source, issue.Line, issue.Message})
+	}
+	if config.LoadError != "" && len(config.Issues) == 0 {
+		findings = append(findings, reportFinding{"failure", config.File, 0, config.LoadError})
+	}
+
+	for _, entitlement := range config.Quarantined {
+		findings = append(findings, reportFinding{"warning", entitlement.Source, entitlement.Line, "entitlement is ignored as it doesn't pin repository_owner, repository_owner_id, repository_id or repository and would match any repository on GitHub, set allow_any_repository to true if this is intended"})
+	}
+	for _, entitlement := range config.Entitlements {
+		if entitlement.AllowAnyRepository && !entitlement.isDeny() && !entitlement.isPinned() {
+			findings = append(findings, reportFinding{"warning", entitlement.Source, entitlement.Line, "entitlement matches workflows of any repository on GitHub as allow_any_repository is set"})
+		}
+	}
+
+	report := ConfigReport{Conclusion: "success", Annotations: []*github.CheckRunAnnotation{}}
+	if config.LoadError != "" {
+		report.Conclusion = "failure"
+		report.Title = "Configuration failed to load"
+		if config.Fallback != nil {
+			report.Title = "Configuration failed to load, the previous configuration is still in use"
+		}
+	} else {
+		if len(findings) > 0 {
+			report.Conclusion = "neutral"
+		}
+		report.Title = fmt.Sprintf("%d entitlement(s) loaded", config.EntitlementCount)
+	}
+
+	var summary strings.Builder
+	if config.LoadError != "" && config.Fallback != nil {
+		fmt.Fprintf(&summary, "The configuration of %s failed to load, the configuration from commit %s is still in use until it is fixed: %s\n", config.Login, config.Fallback.CommitSHA, config.LoadError)
+	} else if config.LoadError != "" {
+		fmt.Fprintf(&summary, "The configuration of %s failed to load, no token is delivered until it is fixed: %s\n", config.Login, config.LoadError)
+	} else {
+		fmt.Fprintf(&summary, "%d entitlement(s) loaded for %s from commit %s.\n", config.EntitlementCount, config.Login, config.CommitSHA)
+	}
+	if len(findings) > 0 {
+		summary.WriteString("\n")
+	}
+	for _, finding := range findings {
+		location := finding.source
+		if finding.line > 0 {
+			location = fmt.Sprintf("%s (line %d)", finding.source, finding.line)
+		}
+		fmt.Fprintf(&summary, "- **%s** %s: %s\n", finding.level, location, finding.message)
+
+		if len(report.Annotations) < maxReportAnnotations && finding.source != "" {
+			line := finding.line
+			if line == 0 {
+				line = 1
+			}
+			report.Annotations = append(report.Annotations, &github.CheckRunAnnotation{
+				Path:            github.String(reportPath(finding.source)),
+				StartLine:       github.Int(line),
+				EndLine:         github.Int(line),
+				AnnotationLevel: github.String(finding.level),
+				Message:         github.String(finding.message),
+			})
+		}
+	}
+	report.Summary = truncate(summary.String(), maxReportSummaryLength)
+	return report
+}
+
+/*
+ * Cut a text to a maximum length in bytes, without splitting a multi-byte character
+ */
+func truncate(text string, maxLength int) string {
+	if len(text) <= maxLength {
+		return text
+	}
+	cut := maxLength
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut]
+}
+
+/*
+ * Get the commit to post the report of a reload triggered by a push on. When several pushes happen in a row, the configuration can be
+ * loaded from a later commit than the pushed one, the report is then left to the reload triggered by the push of that commit.
+ */
+func reportCommit(config *EntitlementConfig, pushedSHA string) (string, bool) {
+	if pushedSHA == "" || (config.CommitSHA != "" && config.CommitSHA != pushedSHA) {
+		return "", false
+	}
+	return pushedSHA, true
+}
+
+/*
+ * Create a check run with the report on a commit of the config repository. A commit status is created instead when
+ * the check run can't be created, e.g. because the app lacks the checks permission.
+ */
+func postConfigReport(ctx context.Context, client *github.Client, owner string, repo string, sha string, report ConfigReport) error {
+	_, _, err := client.Checks.CreateCheckRun(ctx, owner, repo, github.CreateCheckRunOptions{
+		Name:        configReportName,
+		HeadSHA:     sha,
+		Status:      github.String("completed"),
+		Conclusion:  github.String(report.Conclusion),
+		CompletedAt: &github.Timestamp{Time: time.Now()},
+		Output: &github.CheckRunOutput{
+			Title:       github.String(report.Title),
+			Summary:     github.String(report.Summary),
+			Annotations: report.Annotations,
+		},
+	})
+	if err == nil {
+		return nil
+	}
+	log.Printf("couldn't create check run on %s/%s@%s, creating a commit status instead: %s", owner, repo, sha, err)
+
+	state := "success"
+	if report.Conclusion == "failure" {
+		state = "failure"
+	}
+	description := report.Title
+	if report.Conclusion == "neutral" {
+		description = fmt.Sprintf("%s, %d warning(s)", description, len(report.Annotations))
+	}
+	description = truncate(description, maxStatusDescriptionLength)
+	_, _, err = client.Repositories.CreateStatus(ctx, owner, repo, sha, &github.RepoStatus{
+		State:       github.String(state),
+		Description: github.String(description),
+		Context:     github.String(configReportName),
+	})
+	return err
+}
+
+/*
+ * Report the outcome of the load of a configuration on the commit of the config repository which triggered it
+ */
+func (appContext *AppContext) publishConfigReport(config *EntitlementConfig, sha string) error {
+	client, err := newInstallationClient(ghinstallation.NewFromAppsTransport(appContext.appTransport, config.InstallationId))
+	if err != nil {
+		return err
+	}
+	return postConfigReport(context.Background(), client, config.Login, config.Repo, sha, buildConfigReport(config))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/google/go-github/v53/github"
+)
+
+func TestConfigReportWithBroadRules(t *testing.T) {
+	config, err := loadLocalConfig("test/wildcard-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.EntitlementCount = len(config.Entitlements)
+
+	report := buildConfigReport(config)
+	if report.Conclusion != "neutral" || report.Title != "3 entitlement(s) loaded" {
+		t.Errorf("Expected a neutral report with 3 entitlements, got %s: %s", report.Conclusion, report.Title)
+	}
+
+	paths := []string{}
+	for _, annotation := range report.Annotations {
+		if annotation.GetAnnotationLevel() != "warning" || annotation.GetStartLine() != 1 {
+			t.Errorf("Expected a warning on line 1, got %s on line %d", annotation.GetAnnotationLevel(), annotation.GetStartLine())
+		}
+		paths = append(paths, annotation.GetPath())
+	}
+	expectedPaths := []string{"test/wildcard-repo/environment/production/glob-owner.json", "test/wildcard-repo/environment/production/wildcard.json"}
+	if !reflect.DeepEqual(expectedPaths, paths) {
+		t.Errorf("Expected annotations on %v, got %v", expectedPaths, paths)
+	}
+}
+
+func TestConfigReportWithInvalidFile(t *testing.T) {
+	config := NewEntitlementConfig("octodemo", 1, "", "oidc_entitlements", "test/invalid-file.json")
+	config.LoadError = "test/invalid-file.json[1]: unknown field \"repository\""
+	config.Issues = validateConfig("test/invalid-file.json")
+
+	report := buildConfigReport(config)
+	if report.Conclusion != "failure" {
+		t.Errorf("Expected a failed report, got %s", report.Conclusion)
+	}
+
+	expectedAnnotations := []*github.CheckRunAnnotation{
+		{
+			Path:            github.String("test/invalid-file.json"),
+			StartLine:       github.Int(14),
+			EndLine:         github.Int(14),
+			AnnotationLevel: github.String("failure"),
+			Message:         github.String("unknown scopes key 'repository'"),
+		},
+		{
+			Path:            github.String("test/invalid-file.json"),
+			StartLine:       github.Int(10),
+			EndLine:         github.Int(10),
+			AnnotationLevel: github.String("failure"),
+			Message:         github.String("invalid effect 'maybe', expected 'allow' or 'deny'"),
+		},
+	}
+	if !reflect.DeepEqual(expectedAnnotations, report.Annotations) {
+		expectedJson, _ := json.MarshalIndent(expectedAnnotations, "", "  ")
+		gotJson, _ := json.MarshalIndent(report.Annotations, "", "  ")
+		t.Errorf("Expected annotations to be %s, but got %s", string(expectedJson), string(gotJson))
+	}
+}
+
+func TestConfigReportWithFallback(t *testing.T) {
+	config := NewEntitlementConfig("octodemo", 1, "", "oidc_entitlements", "")
+	config.LoadError = "repository not found"
+	config.Fallback = NewEntitlementConfig("octodemo", 1, "", "oidc_entitlements", "")
+	config.Fallback.CommitSHA = "abc123"
+
+	report := buildConfigReport(config)
+	if report.Conclusion != "failure" || report.Title != "Configuration failed to load, the previous configuration is still in use" {
+		t.Errorf("Expected a failed report, got %s: %s", report.Conclusion, report.Title)
+	}
+	if !strings.Contains(report.Summary, "commit abc123 is still in use") {
+		t.Errorf("Expected the summary to name the commit in use, got %s", report.Summary)
+	}
+}
+
+func TestPostConfigReportFallsBackToStatus(t *testing.T) {
+	var status github.RepoStatus
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/repos/octodemo/oidc_entitlements/check-runs":
+			http.Error(w, `{"message": "Resource not accessible by integration"}`, http.StatusForbidden)
+		case "/repos/octodemo/oidc_entitlements/statuses/abc123":
+			json.NewDecoder(req.Body).Decode(&status)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("{}"))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	report := ConfigReport{Conclusion: "failure", Title: "Configuration failed to load"}
+	err := postConfigReport(context.Background(), client, "octodemo", "oidc_entitlements", "abc123", report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.GetState() != "failure" || status.GetContext() != configReportName || status.GetDescription() != "Configuration failed to load" {
+		t.Errorf("Expected a failed commit status, got %+v", status)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if truncated := truncate("entitlements", 20); truncated != "entitlements" {
+		t.Errorf("Expected short text to be kept, got %s", truncated)
+	}
+	// é is 2 bytes long, the cut can't split it
+	if truncated := truncate("café au lait", 4); truncated != "caf" {
+		t.Errorf("Expected text to be cut before the multi-byte character, got %s", truncated)
+	}
+	if truncated := truncate("café au lait", 5); truncated != "café" {
+		t.Errorf("Expected text to be cut after the multi-byte character, got %s", truncated)
+	}
+}
+
+func TestReportCommit(t *testing.T) {
+	config := NewEntitlementConfig("octodemo", 1, "", "oidc_entitlements", "")
+	config.CommitSHA = "abc123"
+	if sha, ok := reportCommit(config, "abc123"); !ok || sha != "abc123" {
+		t.Errorf("Expected the report to be posted on the pushed commit, got %s", sha)
+	}
+	// Another push happened in the meantime, its own reload reports on it
+	if _, ok := reportCommit(config, "def456"); ok {
+		t.Error("Expected no report when the configuration was loaded from another commit")
+	}
+	// The load failed before the commit could be resolved
+	config.CommitSHA = ""
+	if sha, ok := reportCommit(config, "def456"); !ok || sha != "def456" {
+		t.Errorf("Expected the report to be posted on the pushed commit, got %s", sha)
+	}
+}
+
+func TestNewInstallationClientUsesBaseURL(t *testing.T) {
+	appTransport := &ghinstallation.AppsTransport{BaseURL: "https://ghes.example.com/api/v3"}
+	client, err := newInstallationClient(ghinstallation.NewFromAppsTransport(appTransport, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.BaseURL.String() != "https://ghes.example.com/api/v3/" {
+		t.Errorf("Expected the GHES API URL, got %s", client.BaseURL)
+	}
+}
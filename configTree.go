@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/google/go-github/v53/github"
+)
+
+// Ways of reading a config repository, set through CONFIG_LOADER
+const (
+	// git clone and fetch over HTTPS
+	cloneConfigLoader = "clone"
+	// Git Trees and Blobs REST API, for networks where git over HTTPS is blocked
+	apiConfigLoader = "api"
+)
+
+/*
+ * Snapshot of a config repository read through the Git Trees API. The snapshots are kept across reloads so that an unchanged ref
+ * or an unchanged file isn't downloaded again. A snapshot can only be updated by one reload at a time, the file system of a commit
+ * is never modified once built so it can still be read once the snapshot moved to another commit.
+ */
+type ConfigTree struct {
+	mu        sync.Mutex
+	commitSHA string
+	// Content of the JSON files by blob SHA
+	blobs      map[string][]byte
+	filesystem billy.Filesystem
+}
+
+// Snapshots by repository URL
+var configTrees sync.Map
+
+func getConfigTree(url string) *ConfigTree {
+	tree, _ := configTrees.LoadOrStore(url, &ConfigTree{})
+	return tree.(*ConfigTree)
+}
+
+func dropConfigTree(url string) {
+	configTrees.Delete(url)
+}
+
+/*
+ * Bring the snapshot to the latest commit of the ref and set the commit of the configuration.
+ * The ref is resolved with a conditional request using the commit of the snapshot as ETag, GitHub doesn't count it against the rate limit
+ * when the ref didn't move. Otherwise the recursive tree of the new commit is listed and only the blobs which aren't in the snapshot yet are downloaded.
+ */
+func (config *EntitlementConfig) fetchTree(client *github.Client, tree *ConfigTree) (fs.FS, error) {
+	ctx := context.Background()
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	ref := config.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	sha, response, err := client.Repositories.GetCommitSHA1(ctx, config.Login, config.Repo, ref, tree.commitSHA)
+	if response != nil && response.StatusCode == http.StatusNotModified && tree.filesystem != nil {
+		config.CommitSHA = tree.commitSHA
+		return billyFS{tree.filesystem}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	gitTree, _, err := client.Git.GetTree(ctx, config.Login, config.Repo, sha, true)
+	if err != nil {
+		return nil, err
+	}
+	if gitTree.GetTruncated() {
+		return nil, fmt.Errorf("the tree of commit %s has too many entries to be listed through the API", sha)
+	}
+
+	filesystem := memfs.New()
+	blobs := map[string][]byte{}
+	for _, entry := range gitTree.Entries {
+		switch entry.GetType() {
+		case "tree":
+			// Keep the empty folders so that the folders are walked the same way as in a clone
+			if err := filesystem.MkdirAll(entry.GetPath(), 0755); err != nil {
+				return nil, err
+			}
+		case "blob":
+			// Only the JSON files are part of the configuration
+			if !strings.HasSuffix(entry.GetPath(), ".json") {
+				continue
+			}
+			content, found := tree.blobs[entry.GetSHA()]
+			if !found {
+				content, _, err = client.Git.GetBlobRaw(ctx, config.Login, config.Repo, entry.GetSHA())
+				if err != nil {
+					return nil, err
+				}
+			}
+			blobs[entry.GetSHA()] = content
+			if err := util.WriteFile(filesystem, entry.GetPath(), content, 0644); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	tree.commitSHA = sha
+	tree.blobs = blobs
+	tree.filesystem = filesystem
+	config.CommitSHA = sha
+	return billyFS{filesystem}, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v53/github"
+)
+
+/*
+ * Serve the commits, trees and blobs endpoints of a repository whose head commit can be moved
+ */
+type fakeTreeAPI struct {
+	head     string
+	commits  map[string]map[string]string
+	blobs    map[string]string
+	requests map[string]int
+}
+
+func newFakeTreeAPI() *fakeTreeAPI {
+	return &fakeTreeAPI{commits: map[string]map[string]string{}, blobs: map[string]string{}, requests: map[string]int{}}
+}
+
+func (api *fakeTreeAPI) commit(sha string, files map[string]string) {
+	api.commits[sha] = files
+	api.head = sha
+}
+
+func (api *fakeTreeAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/repos/octodemo/oidc_entitlements/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "commits":
+		api.requests["commit"]++
+		if req.Header.Get("If-None-Match") == `"`+api.head+`"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(api.head))
+	case len(parts) == 3 && parts[1] == "trees":
+		api.requests["tree"]++
+		tree := github.Tree{SHA: github.String(parts[2]), Truncated: github.Bool(false)}
+		folders := map[string]bool{}
+		for file, content := range api.commits[parts[2]] {
+			for folder := path.Dir(file); folder != "." && !folders[folder]; folder = path.Dir(folder) {
+				folders[folder] = true
+				tree.Entries = append(tree.Entries, &github.TreeEntry{Path: github.String(folder), Type: github.String("tree")})
+			}
+			sha := fmt.Sprintf("%x", sha1.Sum([]byte(content)))
+			api.blobs[sha] = content
+			tree.Entries = append(tree.Entries, &github.TreeEntry{Path: github.String(file), Type: github.String("blob"), SHA: github.String(sha)})
+		}
+		json.NewEncoder(w).Encode(tree)
+	case len(parts) == 3 && parts[1] == "blobs":
+		api.requests["blob"]++
+		w.Write([]byte(api.blobs[parts[2]]))
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func TestFetchTree(t *testing.T) {
+	api := newFakeTreeAPI()
+	api.commit("c1", map[string]string{
+		"README.md":                 "# Entitlements",
+		"generic.json":              `{"repository_owner": "major-tom", "scopes": {"permissions": {"issues": "read"}}}`,
+		"owner/ziggy/stardust.json": `{"scopes": {"permissions": {"contents": "read"}}}`,
+		"owner/monalisa/.gitkeep":   "",
+	})
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	tree := &ConfigTree{}
+
+	load := func() *EntitlementConfig {
+		config := NewEntitlementConfig("octodemo", 1, "", "oidc_entitlements", "")
+		fsys, err := config.fetchTree(client, tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := config.loadRepository(fsys); err != nil {
+			t.Fatal(err)
+		}
+		return config
+	}
+
+	config := load()
+	sources := []string{}
+	for _, entitlement := range config.Entitlements {
+		sources = append(sources, entitlement.Source)
+	}
+	if config.CommitSHA != "c1" || !reflect.DeepEqual([]string{"generic.json", "owner/ziggy/stardust.json"}, sources) {
+		t.Errorf("Expected the entitlements of commit c1, but got %v at %s", sources, config.CommitSHA)
+	}
+	if config.Entitlements[1].RepositoryOwner != "ziggy" {
+		t.Errorf("Expected the owner folder to set the repository owner, but got %s", config.Entitlements[1].RepositoryOwner)
+	}
+	// Only the JSON files are downloaded
+	expectedRequests := map[string]int{"commit": 1, "tree": 1, "blob": 2}
+	if !reflect.DeepEqual(expectedRequests, api.requests) {
+		t.Errorf("Expected %v requests, but got %v", expectedRequests, api.requests)
+	}
+
+	// Nothing else is downloaded when the ref didn't move
+	config = load()
+	if config.CommitSHA != "c1" || len(config.Entitlements) != 2 {
+		t.Errorf("Expected the entitlements of commit c1 to be kept, but got %d entitlements at %s", len(config.Entitlements), config.CommitSHA)
+	}
+	expectedRequests = map[string]int{"commit": 2, "tree": 1, "blob": 2}
+	if !reflect.DeepEqual(expectedRequests, api.requests) {
+		t.Errorf("Expected %v requests, but got %v", expectedRequests, api.requests)
+	}
+
+	// Only the changed file is downloaded when the ref moved
+	api.commit("c2", map[string]string{
+		"README.md":                 "# Entitlements",
+		"generic.json":              `{"repository_owner": "major-tom", "scopes": {"permissions": {"issues": "write"}}}`,
+		"owner/ziggy/stardust.json": `{"scopes": {"permissions": {"contents": "read"}}}`,
+	})
+	config = load()
+	if config.CommitSHA != "c2" || config.Entitlements[0].Scopes.Permissions.GetIssues() != "write" {
+		t.Errorf("Expected the entitlements of commit c2, but got %+v at %s", config.Entitlements, config.CommitSHA)
+	}
+	expectedRequests = map[string]int{"commit": 3, "tree": 2, "blob": 3}
+	if !reflect.DeepEqual(expectedRequests, api.requests) {
+		t.Errorf("Expected %v requests, but got %v", expectedRequests, api.requests)
+	}
+}
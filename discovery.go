@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+ * The subset of the OpenID Provider Metadata used to validate tokens
+ * See https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+ */
+type OpenIDConfiguration struct {
+	Issuer                           string   `json:"issuer"`
+	JwksURI                          string   `json:"jwks_uri"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+/*
+ * Retrieve the OpenID configuration published by an issuer under /.well-known/openid-configuration.
+ * The configuration needs to advertise the issuer it has been retrieved from.
+ */
+func fetchOpenIDConfiguration(client *http.Client, issuerURL string) (*OpenIDConfiguration, error) {
+	discoveryURL := strings.TrimSuffix(issuerURL, "/") + "/.well-known/openid-configuration"
+	resp, err := client.Get(discoveryURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get OpenID configuration from %s: %w", discoveryURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to get OpenID configuration from %s: status %d", discoveryURL, resp.StatusCode)
+	}
+
+	var configuration OpenIDConfiguration
+	if err := json.NewDecoder(resp.Body).Decode(&configuration); err != nil {
+		return nil, fmt.Errorf("unable to parse OpenID configuration from %s: %w", discoveryURL, err)
+	}
+	if configuration.Issuer == "" || configuration.JwksURI == "" {
+		return nil, fmt.Errorf("OpenID configuration from %s needs an issuer and a jwks_uri", discoveryURL)
+	}
+	// Otherwise the discovery document could change which tokens are accepted, see OpenID Connect Discovery 4.3
+	if configuration.Issuer != issuerURL {
+		return nil, fmt.Errorf("OpenID configuration from %s advertises %s as its issuer", discoveryURL, configuration.Issuer)
+	}
+	return &configuration, nil
+}
+
+/*
+ * Use the OpenID configuration of the issuer to set the JWKS URL and the accepted algorithms
+ */
+func (issuer *Issuer) discover() error {
+	configuration, err := fetchOpenIDConfiguration(&http.Client{Timeout: 10 * time.Second}, issuer.IssuerURL)
+	if err != nil {
+		return err
+	}
+
+	issuer.JwksURL = configuration.JwksURI
+	issuer.jwks = NewJwksCache(configuration.JwksURI)
+	// The JWKS can move, it is discovered again when it can't be refreshed
+	issuer.jwks.issuerURL = issuer.IssuerURL
+
+	// Unsigned tokens are never accepted
+	issuer.Algorithms = []string{}
+	for _, algorithm := range configuration.IDTokenSigningAlgValuesSupported {
+		if algorithm != "none" {
+			issuer.Algorithms = append(issuer.Algorithms, algorithm)
+		}
+	}
+	log.Printf("discovered issuer %s with JWKS %s and algorithms %v", issuer.IssuerURL, issuer.JwksURL, issuer.Algorithms)
+	return nil
+}
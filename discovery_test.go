@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+ * Serve an OpenID configuration and its JWKS
+ */
+func newTestDiscoveryServer(t *testing.T, jwksBytes []byte) *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `{"issuer": "%s", "jwks_uri": "%s/keys", "id_token_signing_alg_values_supported": ["RS256", "none"]}`, server.URL, server.URL)
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, req *http.Request) {
+		w.Write(jwksBytes)
+	})
+	return server
+}
+
+func TestIssuerDiscover(t *testing.T) {
+	jwksBytes, privateKey := testJwks(t, "discovered")
+	server := newTestDiscoveryServer(t, jwksBytes)
+	defer server.Close()
+
+	issuer := NewGitHubIssuer(server.URL, "")
+	if err := issuer.discover(); err != nil {
+		t.Fatal(err)
+	}
+	if issuer.IssuerURL != server.URL || issuer.JwksURL != server.URL+"/keys" {
+		t.Errorf("Expected discovered issuer and JWKS URL, got %s and %s", issuer.IssuerURL, issuer.JwksURL)
+	}
+	if len(issuer.Algorithms) != 1 || issuer.Algorithms[0] != "RS256" {
+		t.Errorf("Expected only RS256 to be accepted, got %v", issuer.Algorithms)
+	}
+
+	_, err := issuer.Authenticate(signTestToken(t, privateKey, "discovered", jwt.MapClaims{"iss": server.URL}))
+	if err != nil {
+		t.Error(err)
+	}
+
+	// A token signed with an algorithm which isn't advertised is rejected
+	token := jwt.NewWithClaims(jwt.SigningMethodRS512, jwt.MapClaims{"iss": server.URL, "exp": 4102444800})
+	token.Header["kid"] = "discovered"
+	signedToken, _ := token.SignedString(privateKey)
+	if _, err := issuer.Authenticate(signedToken); err == nil {
+		t.Error("Should not validate token signed with an algorithm which isn't advertised")
+	}
+}
+
+func TestIssuerDiscoverFailure(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	issuer := NewGitHubIssuer(server.URL, server.URL+"/.well-known/jwks")
+	if err := issuer.discover(); err == nil {
+		t.Error("Expected discovery to fail")
+	}
+	if issuer.JwksURL != server.URL+"/.well-known/jwks" {
+		t.Errorf("Expected JWKS URL to be kept, got %s", issuer.JwksURL)
+	}
+}
+
+func TestIssuerDiscoverMismatch(t *testing.T) {
+	jwksBytes, _ := testJwks(t, "discovered")
+	server := newTestDiscoveryServer(t, jwksBytes)
+	defer server.Close()
+
+	// The configuration needs to advertise exactly the configured issuer
+	issuer := NewGitHubIssuer(server.URL+"/", server.URL+"/.well-known/jwks")
+	if err := issuer.discover(); err == nil {
+		t.Error("Expected discovery to fail when the advertised issuer differs")
+	}
+	if issuer.IssuerURL != server.URL+"/" || issuer.JwksURL != server.URL+"/.well-known/jwks" {
+		t.Errorf("Expected issuer and JWKS URL to be kept, got %s and %s", issuer.IssuerURL, issuer.JwksURL)
+	}
+}
+
+func TestJwksCacheRediscoversMovedJwks(t *testing.T) {
+	jwksBytes, _ := testJwks(t, "discovered")
+	jwksPath := "/keys"
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `{"issuer": "%s", "jwks_uri": "%s%s"}`, server.URL, server.URL, jwksPath)
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, req *http.Request) {
+		if jwksPath != "/keys" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(jwksBytes)
+	})
+	mux.HandleFunc("/rotated/keys", func(w http.ResponseWriter, req *http.Request) {
+		w.Write(jwksBytes)
+	})
+
+	issuer := NewGitHubIssuer(server.URL, "")
+	if err := issuer.discover(); err != nil {
+		t.Fatal(err)
+	}
+	if err := issuer.jwks.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The issuer moves its JWKS
+	jwksPath = "/rotated/keys"
+	if err := issuer.jwks.Refresh(); err != nil {
+		t.Errorf("Expected refresh to discover the new JWKS URL, got %v", err)
+	}
+	if issuer.jwks.url != server.URL+"/rotated/keys" {
+		t.Errorf("Expected JWKS to be read from the new URL, got %s", issuer.jwks.url)
+	}
+}
+
+func TestLoadIssuersWithDiscovery(t *testing.T) {
+	jwksBytes, _ := testJwks(t, "discovered")
+	server := newTestDiscoveryServer(t, jwksBytes)
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "issuers.json")
+	os.WriteFile(path, []byte(fmt.Sprintf(`[{"name": "buildkite", "issuer": "%s", "audiences": ["https://oidc-auth-app.example.com"]}]`, server.URL)), 0600)
+	issuers, err := loadIssuers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issuers[0].JwksURL != server.URL+"/keys" {
+		t.Errorf("Expected JWKS URL to be discovered, got %s", issuers[0].JwksURL)
+	}
+}
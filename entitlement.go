@@ -1,8 +1,23 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// Default effect, the scope of the entitlement is added to the scope of the token
+	EffectAllow = "allow"
+	// The scope of the entitlement is removed from the scope of the token once all allow entitlements have been merged
+	EffectDeny = "deny"
+)
 
 type Entitlement struct {
+	Comment           string `json:"comment,omitempty"`
+	Effect            string `json:"effect,omitempty"`
 	Environment       string `json:"environment,omitempty"`
 	Repository        string `json:"repository,omitempty"`
 	RepositoryId      int64  `json:"repository_id,omitempty"`
@@ -28,50 +43,154 @@ type Entitlement struct {
 	Workflow          string `json:"workflow,omitempty"`
 	WorkflowRef       string `json:"workflow_ref,omitempty"`
 	WorkflowSha       string `json:"workflow_sha,omitempty"`
-	Scopes            Scope  `json:"scopes"`
+	// Claims which are not part of the GitHub claims above, e.g. claims specific to another issuer
+	Claims map[string]string `json:"claims,omitempty"`
+	Scopes Scope             `json:"scopes"`
+	// Explicit opt-out of the check ensuring the entitlement can only match workflows of well identified repositories
+	AllowAnyRepository bool `json:"allow_any_repository,omitempty"`
+	// Where the entitlement was loaded from, e.g. the path of the file in the config repository
+	Source string `json:"-"`
+	// Line of the file at which the entitlement starts in single file mode, 0 when the file holds a single entitlement
+	Line int `json:"-"`
+}
+
+func (e Entitlement) isAllow() bool {
+	return e.Effect == "" || strings.EqualFold(e.Effect, EffectAllow)
 }
 
-func (e Entitlement) regexString() string {
-	return "^actor:" + e.stringFieldValue(e.Actor) +
-		",actor_id:" + e.numericFieldValue(e.ActorId) +
-		",aud:" + e.stringFieldValue(e.Audience) +
-		",base_ref:" + e.stringFieldValue(e.BaseRef) +
-		",environment:" + e.stringFieldValue(e.Environment) +
-		",event_name:" + e.stringFieldValue(e.EventName) +
-		",head_ref:" + e.stringFieldValue(e.HeadRef) +
-		",iss:" + e.stringFieldValue(e.Issuer) +
-		",job_workflow_ref:" + e.stringFieldValue(e.JobWokflowRef) +
-		",job_workflow_sha:" + e.stringFieldValue(e.JobWokflowSha) +
-		",ref:" + e.stringFieldValue(e.Ref) +
-		",ref_type:" + e.stringFieldValue(e.RefType) +
-		",repository:" + e.stringFieldValue(e.Repository) +
-		",repository_id:" + e.numericFieldValue(e.RepositoryId) +
-		",repository_owner:" + e.stringFieldValue(e.RepositoryOwner) +
-		",repository_owner_id:" + e.numericFieldValue(e.RepositoryOwnerId) +
-		",repository_visibility:" + e.stringFieldValue(e.Visibility) +
-		",run_attempt:" + e.numericFieldValue(e.RunAttempt) +
-		",run_id:" + e.numericFieldValue(e.RunId) +
-		",run_number:" + e.numericFieldValue(e.RunNumber) +
-		",runner_environment:" + e.stringFieldValue(e.RunnerEnvironment) +
-		",sub:" + e.stringFieldValue(e.Subject) +
-		",workflow:" + e.stringFieldValue(e.Workflow) +
-		",workflow_ref:" + e.stringFieldValue(e.WorkflowRef) +
-		",workflow_sha:" + e.stringFieldValue(e.WorkflowSha) +
-		"$"
+func (e Entitlement) isDeny() bool {
+	return strings.EqualFold(e.Effect, EffectDeny)
 }
 
-func (e Entitlement) stringFieldValue(value string) string {
-	if value == "" {
-		return ".*"
-	} else {
-		return value
+/*
+ * Check that the entitlement pins at least one claim identifying the repository running the workflow with a value which can't
+ * be matched by a repository of another owner. Without it, any repository on GitHub could match the entitlement.
+ */
+func (e Entitlement) isPinned() bool {
+	// The repository claims of another issuer are mapped claims, they are only meaningful for a single issuer
+	if e.Issuer != "" && !newClaimMatcher("iss", e.Issuer).isExact() {
+		return false
+	}
+	if e.RepositoryOwnerId != 0 || e.RepositoryId != 0 {
+		return true
+	}
+
+	for _, matcher := range e.claimMatchers() {
+		switch matcher.Claim {
+		case "repository_owner":
+			if matcher.isExact() {
+				return true
+			}
+		case "repository":
+			// The owner part of the repository full name (owner/name) needs to be an exact value, e.g. major-tom/*
+			owner, _, found := strings.Cut(matcher.Pattern, "/")
+			if found && owner != "" && newClaimMatcher(matcher.Claim, owner).isExact() {
+				return true
+			}
+		}
 	}
+	return false
 }
 
-func (e Entitlement) numericFieldValue(value int64) string {
-	if value == 0 {
-		return ".*"
-	} else {
-		return fmt.Sprint(value)
+/*
+ * Short description of the entitlement, used in logs
+ */
+func (e Entitlement) String() string {
+	matchers := []string{}
+	for _, matcher := range e.claimMatchers() {
+		matchers = append(matchers, matcher.String())
+	}
+	effect := EffectAllow
+	if e.isDeny() {
+		effect = EffectDeny
+	}
+	return fmt.Sprintf("{effect: %s, claims: [%s], scopes: %s}", effect, strings.Join(matchers, ", "), e.Scopes.String())
+}
+
+/*
+ * Build the list of claim matchers for this entitlement. Claims which are not set in the entitlement are not part of the list
+ * as any value is accepted for those.
+ */
+func (e Entitlement) claimMatchers() []claimMatcher {
+	matchers := []claimMatcher{}
+
+	stringClaims := []struct {
+		claim string
+		value string
+	}{
+		{"actor", e.Actor},
+		{"aud", e.Audience},
+		{"base_ref", e.BaseRef},
+		{"environment", e.Environment},
+		{"event_name", e.EventName},
+		{"head_ref", e.HeadRef},
+		{"iss", e.Issuer},
+		{"job_workflow_ref", e.JobWokflowRef},
+		{"job_workflow_sha", e.JobWokflowSha},
+		{"ref", e.Ref},
+		{"ref_type", e.RefType},
+		{"repository", e.Repository},
+		{"repository_owner", e.RepositoryOwner},
+		{"repository_visibility", e.Visibility},
+		{"runner_environment", e.RunnerEnvironment},
+		{"sub", e.Subject},
+		{"workflow", e.Workflow},
+		{"workflow_ref", e.WorkflowRef},
+		{"workflow_sha", e.WorkflowSha},
+	}
+	numericClaims := []struct {
+		claim string
+		value int64
+	}{
+		{"actor_id", e.ActorId},
+		{"repository_id", e.RepositoryId},
+		{"repository_owner_id", e.RepositoryOwnerId},
+		{"run_attempt", e.RunAttempt},
+		{"run_id", e.RunId},
+		{"run_number", e.RunNumber},
+	}
+
+	for _, stringClaim := range stringClaims {
+		if stringClaim.value != "" {
+			matchers = append(matchers, newClaimMatcher(stringClaim.claim, stringClaim.value))
+		}
+	}
+	for _, numericClaim := range numericClaims {
+		if numericClaim.value != 0 {
+			matchers = append(matchers, newClaimMatcher(numericClaim.claim, fmt.Sprint(numericClaim.value)))
+		}
+	}
+
+	otherClaims := make([]string, 0, len(e.Claims))
+	for claim := range e.Claims {
+		otherClaims = append(otherClaims, claim)
+	}
+	sort.Strings(otherClaims)
+	for _, claim := range otherClaims {
+		matchers = append(matchers, newClaimMatcher(claim, e.Claims[claim]))
+	}
+	return matchers
+}
+
+/*
+ * Check if the claims match every claim set in the entitlement. An entitlement which doesn't set iss only matches the tokens of gitHubIssuer.
+ */
+func (e Entitlement) matches(claims jwt.MapClaims, gitHubIssuer string) bool {
+	return e.firstMismatch(claims, gitHubIssuer) == nil
+}
+
+/*
+ * Return the first claim matcher which doesn't match the claims, nil if all of them match
+ */
+func (e Entitlement) firstMismatch(claims jwt.MapClaims, gitHubIssuer string) *claimMatcher {
+	// Claims of other issuers can be mapped to GitHub claims, an entitlement needs to name the issuer to match them
+	if e.Issuer == "" && isOtherIssuer(claims, gitHubIssuer) {
+		return &claimMatcher{Claim: "iss", Pattern: gitHubIssuer}
+	}
+	for _, matcher := range e.claimMatchers() {
+		if !matcher.matches(claims) {
+			return &matcher
+		}
 	}
+	return nil
 }
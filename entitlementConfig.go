@@ -1,19 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
+	"path"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/bradleyfalzon/ghinstallation/v2"
-	"github.com/go-git/go-git/v5"
 	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/go-github/v53/github"
@@ -25,24 +30,111 @@ type EntitlementConfig struct {
 	GitUrl         string
 	Repo           string
 	File           string
-	Entitlements   []Entitlement
+	// Full name of the branch or tag to load the configuration from, the default branch when empty
+	Ref string
+	// How the config repository is read, either through a clone or through the API
+	Loader       string
+	Entitlements []Entitlement
+	// Entitlements which were ignored as they could match any repository on GitHub
+	Quarantined []Entitlement
+	// Optional installation level policy, nil when there is none
+	Policy *Policy
+	// Outcome of the last load. No token is delivered when the load failed.
+	LoadError        string
+	LoadedAt         time.Time
+	CommitSHA        string
+	EntitlementCount int
+	// Issues found by the validator in the files of the configuration, reported on the config repository
+	Issues []ValidationIssue
+	// Error of the last reload when it failed, this configuration being the last one which loaded and still in use
+	ReloadError string
+	// Configuration still in use as this configuration failed to load, nil when there was none
+	Fallback *EntitlementConfig
+	// Issuer of the OIDC tokens of GitHub Actions, e.g. the one of a GHES instance. The entitlements which don't set iss only match its tokens.
+	// The issuer of github.com is used when empty.
+	GitHubIssuer string
+}
+
+/*
+ * Get the issuer of the OIDC tokens of GitHub Actions the configuration is used with
+ */
+func (config *EntitlementConfig) gitHubIssuer() string {
+	if config.GitHubIssuer == "" {
+		return gitHubIssuerURL
+	}
+	return config.GitHubIssuer
+}
+
+/*
+ * Check that the policy of the configuration allows the owner of the repository running the workflow to request a token
+ */
+func (config *EntitlementConfig) allows(claims jwt.MapClaims) bool {
+	return config.Policy.allows(claims, config.gitHubIssuer())
 }
 
 func NewEntitlementConfig(Login string, InstallationId int64, GitUrl, Repo, File string) *EntitlementConfig {
 	entitlements := make([]Entitlement, 0)
-	return &EntitlementConfig{Login, InstallationId, GitUrl, Repo, File, entitlements}
+	return &EntitlementConfig{Login: Login, InstallationId: InstallationId, GitUrl: GitUrl, Repo: Repo, File: File,
+		Entitlements: entitlements, Quarantined: make([]Entitlement, 0)}
 }
 
-func (config *EntitlementConfig) load(appTransport *ghinstallation.AppsTransport) error {
-	itr := ghinstallation.NewFromAppsTransport(appTransport, config.InstallationId)
+/*
+ * Load the configuration and record the outcome of the load
+ */
+func (config *EntitlementConfig) loadAndRecord(appTransport *ghinstallation.AppsTransport, changes *ConfigChanges) error {
+	err := config.load(appTransport, changes)
+	config.LoadedAt = time.Now().UTC()
+	config.EntitlementCount = len(config.Entitlements)
+	if err != nil {
+		config.LoadError = err.Error()
+	}
+	return err
+}
+
+/*
+ * Create a client of the GitHub API authenticated with an installation transport of the app
+ */
+func newInstallationClient(itr *ghinstallation.Transport) (*github.Client, error) {
 	// Use installation transport with github.com/google/go-github
 	client := github.NewClient(&http.Client{Transport: itr})
+	// On GHES, the API is served by the instance
+	if itr.BaseURL != "" {
+		baseURL, err := url.Parse(strings.TrimSuffix(itr.BaseURL, "/") + "/")
+		if err != nil {
+			return nil, err
+		}
+		client.BaseURL = baseURL
+	}
+	return client, nil
+}
+
+/*
+ * Load the configuration. In repository mode, only the changed files are parsed when changes are given.
+ */
+func (config *EntitlementConfig) load(appTransport *ghinstallation.AppsTransport, changes *ConfigChanges) error {
+	itr := ghinstallation.NewFromAppsTransport(appTransport, config.InstallationId)
+	client, err := newInstallationClient(itr)
+	if err != nil {
+		return err
+	}
 
 	if config.File != "" {
-		log.Printf("loading config for org %s from file %s in repo %s\n", config.Login, config.File, config.Repo)
+		log.Printf("loading config for org %s from file %s in repo %s at %s\n", config.Login, config.File, config.Repo, config.refName())
+
+		// Pin the commit so that the config and policy files are read from the same commit
+		ref := config.Ref
+		if ref == "" {
+			ref = "HEAD"
+		}
+		sha, _, err := client.Repositories.GetCommitSHA1(context.Background(), config.Login, config.Repo, ref, "")
+		if err != nil {
+			log.Printf("couldn't get the head commit of %s in repo %s", config.refName(), config.Repo)
+			return err
+		}
+		config.CommitSHA = sha
 
 		// Retrieve the oidc_entitlements.json file from the .github-private repository in the organization that owns the installation
-		fileContent, _, _, err := client.Repositories.GetContents(context.Background(), config.Login, config.Repo, config.File, &github.RepositoryContentGetOptions{})
+		fileContent, _, _, err := client.Repositories.GetContents(context.Background(), config.Login, config.Repo, config.File, &github.RepositoryContentGetOptions{Ref: sha})
 		if err != nil {
 			log.Println("couldn't download file")
 			return err
@@ -55,17 +147,44 @@ func (config *EntitlementConfig) load(appTransport *ghinstallation.AppsTransport
 			return err
 		}
 
-		// Parse the oidc_entitlements.json file as JSON
-		err = json.Unmarshal([]byte(content), &(config.Entitlements))
+		config.Issues = validateConfigContent(config.File, []byte(content))
+		err = config.parseConfigFile([]byte(content))
 		if err != nil {
-			log.Printf("failed to parse JSON file %s", config.File)
 			return err
 		}
-	} else {
-		log.Printf("loading config for org %s from repo %s/%s/%s\n", config.Login, config.GitUrl, config.Login, config.Repo)
 
-		// Need to clean up the download directory before cloning the repo
-		os.RemoveAll(fmt.Sprintf("/tmp/%s/%s", config.Login, config.Repo))
+		// Retrieve the optional policy file from the same repository
+		policyContent, _, response, err := client.Repositories.GetContents(context.Background(), config.Login, config.Repo, policyFileName, &github.RepositoryContentGetOptions{Ref: sha})
+		if err != nil && (response == nil || response.StatusCode != http.StatusNotFound) {
+			log.Printf("couldn't download file %s", policyFileName)
+			return err
+		}
+		if err == nil {
+			content, err := policyContent.GetContent()
+			if err != nil {
+				log.Println("couldn't get file content as string")
+				return err
+			}
+			config.Policy, err = decodePolicy(policyFileName, strings.NewReader(content))
+			if err != nil {
+				log.Printf("failed to parse policy file %s: %s", policyFileName, err)
+				return err
+			}
+		}
+	} else if config.Loader == apiConfigLoader {
+		log.Printf("loading config for org %s from repo %s/%s/%s at %s through the API\n", config.Login, config.GitUrl, config.Login, config.Repo, config.refName())
+
+		// The snapshot is kept in memory across reloads so that a reload only downloads the changed files
+		fsys, err := config.fetchTree(client, getConfigTree(fmt.Sprintf("%s/%s/%s", config.GitUrl, config.Login, config.Repo)))
+		if err != nil {
+			log.Printf("couldn't read the tree of %s of repo %s/%s/%s", config.refName(), config.GitUrl, config.Login, config.Repo)
+			return err
+		}
+		if err := config.loadRepositorySnapshot(fsys, changes); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("loading config for org %s from repo %s/%s/%s at %s\n", config.Login, config.GitUrl, config.Login, config.Repo, config.refName())
 
 		token, err := itr.Token(context.Background())
 		if err != nil {
@@ -73,41 +192,162 @@ func (config *EntitlementConfig) load(appTransport *ghinstallation.AppsTransport
 			return err
 		}
 
-		_, err = git.PlainClone(fmt.Sprintf("/tmp/%s/%s", config.Login, config.Repo), false, &git.CloneOptions{
-			URL:      fmt.Sprintf("%s/%s/%s", config.GitUrl, config.Login, config.Repo),
-			Auth:     &githttp.BasicAuth{Username: "username", Password: token},
-			Progress: os.Stdout,
-		})
+		// The clone is kept in memory across reloads so that a reload only fetches the new commits
+		url := fmt.Sprintf("%s/%s/%s", config.GitUrl, config.Login, config.Repo)
+		clone := getConfigClone(url)
+		clone.mu.Lock()
+		defer clone.mu.Unlock()
+
+		repository, err := config.cloneOrFetch(clone, url, &githttp.BasicAuth{Username: "username", Password: token})
 		if err != nil {
-			log.Printf("couldn't clone repo %s/%s/%s", config.GitUrl, config.Login, config.Repo)
+			log.Printf("couldn't clone %s of repo %s/%s/%s", config.refName(), config.GitUrl, config.Login, config.Repo)
 			return err
 		}
-
-		// iterate over all files in the directory
-		files, err := os.ReadDir(fmt.Sprintf("/tmp/%s/%s", config.Login, config.Repo))
+		head, err := repository.Head()
 		if err != nil {
-			log.Printf("couldn't read directory /tmp/%s/%s", config.Login, config.Repo)
+			log.Printf("couldn't get the head commit of repo %s/%s/%s", config.GitUrl, config.Login, config.Repo)
+			return err
+		}
+		config.CommitSHA = head.Hash().String()
+		worktree, err := repository.Worktree()
+		if err != nil {
+			return err
+		}
+		if err := config.loadRepositorySnapshot(billyFS{worktree.Filesystem}, changes); err != nil {
 			return err
 		}
-		config.loadFolder(fmt.Sprintf("/tmp/%s/%s", config.Login, config.Repo), files, true)
-
 	}
 	log.Printf("Loaded %d entitlements for org %s", len(config.Entitlements), config.Login)
 	return nil
 }
 
+/*
+ * Parse all the files of the config repository. The source of the entitlements is their path relative to the root of the repository.
+ */
+func (config *EntitlementConfig) loadRepository(fsys fs.FS) error {
+	config.Issues = validateConfigFolder(fsys, ".")
+
+	err := config.loadFolder(fsys, ".", true)
+	if err != nil {
+		return err
+	}
+	return config.loadPolicyFile(fsys, policyFileName)
+}
+
+/*
+ * Parse the files of the config repository at the commit of the configuration, only the changed files when the changes lead to this commit
+ */
+func (config *EntitlementConfig) loadRepositorySnapshot(fsys fs.FS, changes *ConfigChanges) error {
+	// The changes only describe the snapshot when no other commit was pushed in the meantime
+	if changes != nil && changes.After == config.CommitSHA {
+		return config.loadRepositoryChanges(fsys, changes)
+	}
+	return config.loadRepository(fsys)
+}
+
+/*
+ * Get the full name of a ref, a name which doesn't start with refs/ being a branch name
+ */
+func fullRefName(ref string) string {
+	if ref == "" || strings.HasPrefix(ref, "refs/") {
+		return ref
+	}
+	return "refs/heads/" + ref
+}
+
+func (config *EntitlementConfig) refName() string {
+	if config.Ref == "" {
+		return "the default branch"
+	}
+	return config.Ref
+}
+
+/*
+ * Parse the content of a single file configuration, i.e. an array of entitlements
+ */
+func (config *EntitlementConfig) parseConfigFile(content []byte) error {
+	// Parse the oidc_entitlements.json file as JSON
+	var rawEntitlements []json.RawMessage
+	err := json.Unmarshal(content, &rawEntitlements)
+	if err != nil {
+		log.Printf("failed to parse JSON file %s", config.File)
+		return err
+	}
+
+	lines, err := arrayElementLines(content)
+	if err != nil {
+		log.Printf("failed to parse JSON file %s", config.File)
+		return err
+	}
+
+	for index, rawEntitlement := range rawEntitlements {
+		entitlement, err := decodeEntitlement(fmt.Sprintf("%s[%d]", config.File, index), bytes.NewReader(rawEntitlement))
+		if err != nil {
+			log.Printf("failed to parse JSON file %s: %s", config.File, err)
+			return err
+		}
+		entitlement.Line = lines[index]
+		config.addEntitlement(entitlement)
+	}
+	return nil
+}
+
+/*
+ * Add an entitlement to the configuration, unless it is an allow entitlement that could match a workflow from any repository on GitHub.
+ * Such an entitlement is quarantined, unless it explicitly opts out of this check with allow_any_repository.
+ */
+func (config *EntitlementConfig) addEntitlement(entitlement Entitlement) {
+	if !entitlement.isDeny() && !entitlement.AllowAnyRepository && !entitlement.isPinned() {
+		log.Printf("quarantining entitlement %s for org %s as it doesn't pin repository_owner, repository_owner_id, repository_id or repository and would match any repository on GitHub", entitlement.Source, config.Login)
+		config.Quarantined = append(config.Quarantined, entitlement)
+		return
+	}
+	config.Entitlements = append(config.Entitlements, entitlement)
+}
+
+/*
+ * Strictly decode a single entitlement: unknown fields, unknown permissions and invalid values are rejected
+ * so that a typo can't turn a narrow rule into a wildcard.
+ */
+func decodeEntitlement(source string, reader io.Reader) (Entitlement, error) {
+	var entitlement Entitlement
+
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&entitlement)
+	if err != nil {
+		return entitlement, ValidationIssue{Source: source, Message: strings.TrimPrefix(err.Error(), "json: ")}
+	}
+
+	issues := entitlement.validate(source)
+	if len(issues) > 0 {
+		errs := []error{}
+		for _, issue := range issues {
+			errs = append(errs, issue)
+		}
+		return entitlement, errors.Join(errs...)
+	}
+
+	entitlement.Source = source
+	return entitlement, nil
+}
+
 /*
  * Strip all the permissions but the one matching the one defined by the folder name .e.g. organization/<permissionName>
  */
-func (config *EntitlementConfig) stripAllPermissionsBut(permissionName string, permission string, entitlement *Entitlement) {
+func (config *EntitlementConfig) stripAllPermissionsBut(permissionName string, permission string, entitlement *Entitlement) error {
 	jsonString := fmt.Sprintf(`{"%s": "%s"}`, permissionName, permission)
 	permissionObj := github.InstallationPermissions{}
 
-	err := json.Unmarshal([]byte(jsonString), &permissionObj)
+	decoder := json.NewDecoder(strings.NewReader(jsonString))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&permissionObj)
 	if err != nil {
 		log.Printf("failed to create permission %s", jsonString)
+		return fmt.Errorf("unknown permission '%s'", permissionName)
 	}
 	entitlement.Scopes.Permissions = permissionObj
+	return nil
 }
 
 /*
@@ -129,7 +369,21 @@ func (config *EntitlementConfig) stripAllOrgPermissions(entitlement *Entitlement
 	}
 }
 
-func (config *EntitlementConfig) loadFolder(path string, files []fs.DirEntry, isRoot bool) error {
+/*
+ * The directories below are not supposed to contain entitlement files, the files they directly contain are ignored
+ */
+func isIgnoredFolder(folder string) bool {
+	switch path.Base(filepath.ToSlash(folder)) {
+	case "repositories", "environment", "owner", "organization":
+		return true
+	}
+	return false
+}
+
+/*
+ * Load a single entitlement file of a repository based configuration, applying the semantic of the folders it is stored in
+ */
+func (config *EntitlementConfig) loadEntitlementFile(fsys fs.FS, fullPath string, isRoot bool) error {
 	// Regex to find the section right after /repositories/ in the path
 	targetRepoRegex := regexp.MustCompile(`\/repositories\/([^\/]+)\/`)
 	// Regex to find the section right after /owner/ in the path
@@ -140,85 +394,104 @@ func (config *EntitlementConfig) loadFolder(path string, files []fs.DirEntry, is
 	envRegex := regexp.MustCompile(`.*\/environment\/([^\/]+)\/`)
 	// Regex to find the section right after /organization/ in the path
 	orgRegex := regexp.MustCompile(`.*\/organization\/([^\/]+)\/(read|admin|write)\/`)
+	// Regex to check whether the path is under an organization/<permission> folder
+	orgFolderRegex := regexp.MustCompile(`\/organization\/[^\/]+\/`)
 
-	skipFiles := false
-	// the directories below are not supposed to contain entitlement files
-	if strings.HasSuffix(path, "/repositories") || strings.HasSuffix(path, "/environment") || strings.HasSuffix(path, "/owner") || strings.HasSuffix(path, "/organization") || strings.HasSuffix(path, "/repository/") {
-		skipFiles = true
+	jsonFile, err := fsys.Open(fullPath)
+	if err != nil {
+		log.Printf("couldn't open file %s: %s", fullPath, err)
+		return err
 	}
+	defer jsonFile.Close()
 
-	for _, file := range files {
-		fullPath := fmt.Sprintf("%s/%s", path, file.Name())
-
-		if strings.HasSuffix(file.Name(), ".json") && !skipFiles {
-			// This is a JSON configuration file
+	// Parse the oidc_entitlements.json file as JSON
+	entitlement, err := decodeEntitlement(fullPath, jsonFile)
+	if err != nil {
+		log.Printf("failed to parse JSON file %s: %s", fullPath, err)
+		return err
+	}
 
-			jsonFile, err := os.Open(fullPath)
-			if err != nil {
-				log.Printf("couldn't open file %s: %s", fullPath, err)
-				return err
-			}
-			defer jsonFile.Close()
+	// The path is relative to the root of the file system, the folders are matched from there
+	semanticPath := "/" + fullPath
 
-			// Parse the oidc_entitlements.json file as JSON
-			var entitlement Entitlement
-			err = json.NewDecoder(jsonFile).Decode(&entitlement)
-			if err != nil {
-				log.Printf("failed to parse JSON file %s: %s", fullPath, err)
-				return err
-			}
+	// an owner (of a client repository) is present in the path, so we can use it as the owner of the repository in the claims
+	ownerName := ownerRegex.FindStringSubmatch(semanticPath)
+	if ownerName != nil {
+		entitlement.RepositoryOwner = ownerName[1]
+	}
 
-			// an owner (of a client repository) is present in the path, so we can use it as the owner of the repository in the claims
-			ownerName := ownerRegex.FindStringSubmatch(fullPath)
-			if ownerName != nil {
-				entitlement.RepositoryOwner = ownerName[1]
-			}
+	sourceRepoName := sourceRepoRegex.FindStringSubmatch(semanticPath)
+	if sourceRepoName != nil && entitlement.RepositoryOwner != "" {
+		// a client repository name is present in the path, so we can use it as the repository full name (owner/name) in the claims
+		entitlement.Repository = fmt.Sprintf("%s/%s", entitlement.RepositoryOwner, sourceRepoName[1])
+	}
 
-			sourceRepoName := sourceRepoRegex.FindStringSubmatch(fullPath)
-			if sourceRepoName != nil && entitlement.RepositoryOwner != "" {
-				// a client repository name is present in the path, so we can use it as the repository full name (owner/name) in the claims
-				entitlement.Repository = fmt.Sprintf("%s/%s", entitlement.RepositoryOwner, sourceRepoName[1])
-			}
+	repoName := targetRepoRegex.FindStringSubmatch(semanticPath)
+	if repoName != nil {
+		// A target repository name is present in the path, so we can use it as the repository name in the scope
+		// Any previously set list of repositories is discarded
+		entitlement.Scopes.Repositories = repoName[1:]
+	}
 
-			repoName := targetRepoRegex.FindStringSubmatch(fullPath)
-			if repoName != nil {
-				// A target repository name is present in the path, so we can use it as the repository name in the scope
-				// Any previously set list of repositories is discarded
-				entitlement.Scopes.Repositories = repoName[1:]
-			}
+	// an environment is present in the path, so we can use it in the claims
+	envName := envRegex.FindStringSubmatch(semanticPath)
+	if envName != nil {
+		entitlement.Environment = envName[1]
+	}
 
-			// an environment is present in the path, so we can use it in the claims
-			envName := envRegex.FindStringSubmatch(fullPath)
-			if envName != nil {
-				entitlement.Environment = envName[1]
-			}
+	orgPermissionName := orgRegex.FindStringSubmatch(semanticPath)
+	if orgPermissionName != nil {
+		// we are under the orgnization/<permission> folder, so we can use the folder name as the unique permission name
+		err = config.stripAllPermissionsBut(fmt.Sprintf("organization_%s", orgPermissionName[1]), orgPermissionName[2], &entitlement)
+		if err != nil {
+			return ValidationIssue{Source: fullPath, Message: err.Error()}
+		}
+		// Whatever repo access needs to be removed
+		entitlement.Scopes.Repositories = nil
+
+	} else if orgFolderRegex.MatchString(semanticPath) {
+		// Loading it as an ordinary entitlement would grant its repository permissions on all the repositories
+		return ValidationIssue{Source: fullPath, Message: missingOrgLevelFolderMessage}
+	} else if !isRoot {
+		// We are not under the orgnization/<permission> folder and not at the root, so we need to strip all organization permissions
+		config.stripAllOrgPermissions(&entitlement)
+	}
 
-			orgPermissionName := orgRegex.FindStringSubmatch(fullPath)
-			if orgPermissionName != nil {
-				// we are under the orgnization/<permission> folder, so we can use the folder name as the unique permission name
-				config.stripAllPermissionsBut(fmt.Sprintf("organization_%s", orgPermissionName[1]), orgPermissionName[2], &entitlement)
-				// Whatever repo access needs to be removed
-				entitlement.Scopes.Repositories = nil
+	config.addEntitlement(entitlement)
+	return nil
+}
 
-			} else if !isRoot {
-				// We are not under the orgnization/<permission> folder and not at the root, so we need to strip all organization permissions
-				config.stripAllOrgPermissions(&entitlement)
-			}
+/*
+ * Load the entitlement files of a folder and of its subfolders, the folder being a path within the file system
+ */
+func (config *EntitlementConfig) loadFolder(fsys fs.FS, folder string, isRoot bool) error {
+	files, err := fs.ReadDir(fsys, folder)
+	if err != nil {
+		log.Printf("couldn't read directory %s: %s", folder, err)
+		return err
+	}
+	skipFiles := isIgnoredFolder(folder)
 
-			config.Entitlements = append(config.Entitlements, entitlement)
+	for _, file := range files {
+		fullPath := path.Join(folder, file.Name())
 
-		} else if file.IsDir() && file.Name() != ".git" {
-			// This is a subfolder, we need to load it recursively
+		if isRoot && file.Name() == policyFileName {
+			// The policy file is not an entitlement
+			continue
+		}
 
-			subFolderPath := fmt.Sprintf("%s/%s", path, file.Name())
-			subFolderFiles, err := os.ReadDir(subFolderPath)
+		if strings.HasSuffix(file.Name(), ".json") && !skipFiles {
+			// This is a JSON configuration file
+			err := config.loadEntitlementFile(fsys, fullPath, isRoot)
 			if err != nil {
-				log.Printf("couldn't read directory %s: %s", subFolderPath, err)
 				return err
 			}
-			err = config.loadFolder(subFolderPath, subFolderFiles, false)
+
+		} else if file.IsDir() && file.Name() != ".git" {
+			// This is a subfolder, we need to load it recursively
+			err := config.loadFolder(fsys, fullPath, false)
 			if err != nil {
-				log.Printf("couldn't load folder %s: %s", subFolderPath, err)
+				log.Printf("couldn't load folder %s: %s", fullPath, err)
 				return err
 			}
 		}
@@ -229,17 +502,75 @@ func (config *EntitlementConfig) loadFolder(path string, files []fs.DirEntry, is
 /*
  * Several configs could match the claims. We need to merge the scopes of all matching configs into a single scope.
  * This is done by merging the repositories and permissions of all matching configs. In case of conflict, the highest permission is kept (admin > write > read)
+ * Once all the allow entitlements are merged, the scopes of the matching deny entitlements are subtracted, so deny always wins over allow.
  */
 func (config *EntitlementConfig) computeScopes(claims jwt.MapClaims) *Scope {
 	scope := NewScope()
-
-	strMapClaims := stringifyMapClaims(claims)
+	denyEntitlements := []Entitlement{}
 
 	for _, entitlement := range config.Entitlements {
-		match, _ := regexp.MatchString(entitlement.regexString(), strMapClaims)
-		if match {
+		if !entitlement.matches(claims, config.gitHubIssuer()) {
+			continue
+		}
+		if entitlement.isDeny() {
+			denyEntitlements = append(denyEntitlements, entitlement)
+		} else if entitlement.isAllow() {
 			scope.merge(entitlement.Scopes)
+		} else {
+			log.Printf("ignoring entitlement with unknown effect '%s' on org %s: %s", entitlement.Effect, config.Login, entitlement.String())
+		}
+	}
+
+	for _, entitlement := range denyEntitlements {
+		changes := scope.subtract(entitlement.Scopes)
+		if len(changes) > 0 {
+			log.Printf("deny entitlement %s on org %s %s", entitlement.String(), config.Login, strings.Join(changes, ", "))
 		}
 	}
 	return scope
 }
+
+/*
+ * Get the source of the entitlements matching these claims, allow and deny alike
+ */
+func (config *EntitlementConfig) matchingSources(claims jwt.MapClaims) []string {
+	sources := []string{}
+	for _, entitlement := range config.Entitlements {
+		if entitlement.matches(claims, config.gitHubIssuer()) {
+			sources = append(sources, entitlement.Source)
+		}
+	}
+	return sources
+}
+
+/*
+ * Explain how each entitlement of the configuration behaves with these claims, without computing the scope.
+ * The entitlements which don't match are only listed when includeUnmatched is set, as they could reveal the rules granting access to other repositories.
+ */
+func (config *EntitlementConfig) explain(claims jwt.MapClaims, includeUnmatched bool) []EntitlementExplanation {
+	explanations := []EntitlementExplanation{}
+
+	for _, entitlement := range config.Entitlements {
+		explanation := EntitlementExplanation{
+			Source:  entitlement.Source,
+			Effect:  entitlement.Effect,
+			Matched: true,
+			Scopes:  entitlement.Scopes,
+		}
+		if explanation.Effect == "" {
+			explanation.Effect = EffectAllow
+		}
+
+		mismatch := entitlement.firstMismatch(claims, config.gitHubIssuer())
+		if mismatch != nil && !includeUnmatched {
+			continue
+		}
+		if mismatch != nil {
+			explanation.Matched = false
+			explanation.FailedClaim = mismatch.Claim
+			explanation.ExpectedValue = mismatch.Pattern
+		}
+		explanations = append(explanations, explanation)
+	}
+	return explanations
+}
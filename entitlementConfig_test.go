@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/go-github/v53/github"
@@ -96,11 +97,7 @@ func TestSimpleRepoConfig(t *testing.T) {
 
 	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
 
-	files, err := os.ReadDir(path)
-	if err != nil {
-		t.Error(err)
-	}
-	err = config.loadFolder(path, files, true)
+	err := config.loadFolder(os.DirFS("."), path, true)
 	if err != nil {
 		t.Error(err)
 	}
@@ -113,6 +110,7 @@ func TestSimpleRepoConfig(t *testing.T) {
 			Repository:      "major-tom/starman",
 			RepositoryOwner: "major-tom",
 			Environment:     "development",
+			Source:          "test/simple-repo/generic.json",
 			Scopes: Scope{
 				Permissions: github.InstallationPermissions{
 					Contents:                   &write,
@@ -135,11 +133,7 @@ func TestGoodRepoConfig(t *testing.T) {
 
 	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
 
-	files, err := os.ReadDir(path)
-	if err != nil {
-		t.Error(err)
-	}
-	err = config.loadFolder(path, files, true)
+	err := config.loadFolder(os.DirFS("."), path, true)
 	if err != nil {
 		t.Error(err)
 	}
@@ -153,6 +147,8 @@ func TestGoodRepoConfig(t *testing.T) {
 			Repository:      "major-tom/test-three-repo",
 			RepositoryOwner: "major-tom",
 			Environment:     "development",
+			Comment:         "this is at the root of the repo, we can set any property",
+			Source:          "test/good-repo/generic.json",
 			Scopes: Scope{
 				Repositories: []string{
 					"codespace-oddity",
@@ -167,6 +163,7 @@ func TestGoodRepoConfig(t *testing.T) {
 		{
 			RepositoryOwner: "major-tom",
 			Environment:     "development",
+			Source:          "test/good-repo/organization/administration/read/owner/major-tom/environment/development/admin_read.json",
 			Scopes: Scope{
 				Permissions: github.InstallationPermissions{
 					OrganizationAdministration: &read,
@@ -178,6 +175,7 @@ func TestGoodRepoConfig(t *testing.T) {
 			Repository:      "major-tom/test-two-repo",
 			RepositoryOwner: "major-tom",
 			Environment:     "development",
+			Source:          "test/good-repo/repositories/codespace-oddity/codespace-oddity-generic.json",
 			Scopes: Scope{
 				Permissions: github.InstallationPermissions{
 					Contents: &write,
@@ -190,6 +188,7 @@ func TestGoodRepoConfig(t *testing.T) {
 			RepositoryOwner: "major-tom",
 			Environment:     "development",
 			Workflow:        "Workflow 1",
+			Source:          "test/good-repo/repositories/codespace-oddity/owner/major-tom/environment/development/test-workflow.json",
 			Scopes: Scope{
 				Permissions: github.InstallationPermissions{
 					Contents: &write,
@@ -202,6 +201,8 @@ func TestGoodRepoConfig(t *testing.T) {
 			Repository:      "major-tom/starman",
 			RepositoryOwner: "major-tom",
 			Environment:     "development",
+			Comment:         "the repository will be stripped from the scope as it is provided by the path",
+			Source:          "test/good-repo/repositories/codespace-oddity/owner/major-tom/repository/starman/entitlements.json",
 			Scopes: Scope{
 				Permissions: github.InstallationPermissions{
 					Contents: &write,
@@ -214,6 +215,7 @@ func TestGoodRepoConfig(t *testing.T) {
 			Repository:      "major-tom/test-repo",
 			RepositoryOwner: "major-tom",
 			Environment:     "development",
+			Source:          "test/good-repo/repositories/codespace-oddity/owner/major-tom/test-repo-dev.json",
 			Scopes: Scope{
 				Permissions: github.InstallationPermissions{
 					Contents: &write,
@@ -237,11 +239,7 @@ func TestDeepEnvRepoConfig(t *testing.T) {
 
 	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
 
-	files, err := os.ReadDir(path)
-	if err != nil {
-		t.Error(err)
-	}
-	err = config.loadFolder(path, files, true)
+	err := config.loadFolder(os.DirFS("."), path, true)
 	if err != nil {
 		t.Error(err)
 	}
@@ -255,6 +253,7 @@ func TestDeepEnvRepoConfig(t *testing.T) {
 			RepositoryOwner: "major-tom",
 			Environment:     "production",
 			Workflow:        "Workflow 1",
+			Source:          "test/deep-env-repo/repositories/codespace-oddity/owner/major-tom/repository/starman/environment/production/test-workflow.json",
 			Scopes: Scope{
 				Repositories: []string{
 					"codespace-oddity",
@@ -278,11 +277,7 @@ func TestDeepRepoRepoConfig(t *testing.T) {
 
 	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
 
-	files, err := os.ReadDir(path)
-	if err != nil {
-		t.Error(err)
-	}
-	err = config.loadFolder(path, files, true)
+	err := config.loadFolder(os.DirFS("."), path, true)
 	if err != nil {
 		t.Error(err)
 	}
@@ -296,6 +291,7 @@ func TestDeepRepoRepoConfig(t *testing.T) {
 			RepositoryOwner: "major-tom",
 			Environment:     "production",
 			Workflow:        "Workflow 1",
+			Source:          "test/deep-repo-repo/repositories/codespace-oddity/environment/production/owner/major-tom/repository/starman/test-workflow.json",
 			Scopes: Scope{
 				Repositories: []string{
 					"codespace-oddity",
@@ -319,11 +315,7 @@ func TestOwnerEnvRepoConfig(t *testing.T) {
 
 	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
 
-	files, err := os.ReadDir(path)
-	if err != nil {
-		t.Error(err)
-	}
-	err = config.loadFolder(path, files, true)
+	err := config.loadFolder(os.DirFS("."), path, true)
 	if err != nil {
 		t.Error(err)
 	}
@@ -336,6 +328,7 @@ func TestOwnerEnvRepoConfig(t *testing.T) {
 			RepositoryOwner: "major-tom",
 			Environment:     "production",
 			Workflow:        "Workflow 1",
+			Source:          "test/owner-env-repo/owner/major-tom/environment/production/test-workflow.json",
 			Scopes: Scope{
 				Permissions: github.InstallationPermissions{
 					Contents: &write,
@@ -359,11 +352,7 @@ func TestRepoRepoRepoConfig(t *testing.T) {
 
 	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
 
-	files, err := os.ReadDir(path)
-	if err != nil {
-		t.Error(err)
-	}
-	err = config.loadFolder(path, files, true)
+	err := config.loadFolder(os.DirFS("."), path, true)
 	if err != nil {
 		t.Error(err)
 	}
@@ -373,7 +362,9 @@ func TestRepoRepoRepoConfig(t *testing.T) {
 	expectedEntitlements := []Entitlement{
 		// from test/repo-repo-repo/repositories/codespace-oddity/repository/starman/test-workflow.json
 		{
-			Workflow: "Workflow 1",
+			AllowAnyRepository: true,
+			Workflow:           "Workflow 1",
+			Source:             "test/repo-repo-repo/repositories/codespace-oddity/repositories/starman/test-workflow.json",
 			Scopes: Scope{
 				Repositories: []string{
 					"codespace-oddity",
@@ -397,11 +388,7 @@ func TestEnvRepoConfig(t *testing.T) {
 
 	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
 
-	files, err := os.ReadDir(path)
-	if err != nil {
-		t.Error(err)
-	}
-	err = config.loadFolder(path, files, true)
+	err := config.loadFolder(os.DirFS("."), path, true)
 	if err != nil {
 		t.Error(err)
 	}
@@ -411,8 +398,10 @@ func TestEnvRepoConfig(t *testing.T) {
 	expectedEntitlements := []Entitlement{
 		// from test/env-repo/environment/production/test-workflow.json
 		{
-			Environment: "production",
-			Workflow:    "Workflow 1",
+			Environment:        "production",
+			AllowAnyRepository: true,
+			Workflow:           "Workflow 1",
+			Source:             "test/env-repo/environment/production/test-workflow.json",
 			Scopes: Scope{
 				Permissions: github.InstallationPermissions{
 					Contents: &write,
@@ -433,11 +422,7 @@ func TestEnvRepoRepoConfig(t *testing.T) {
 
 	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
 
-	files, err := os.ReadDir(path)
-	if err != nil {
-		t.Error(err)
-	}
-	err = config.loadFolder(path, files, true)
+	err := config.loadFolder(os.DirFS("."), path, true)
 	if err != nil {
 		t.Error(err)
 	}
@@ -447,8 +432,10 @@ func TestEnvRepoRepoConfig(t *testing.T) {
 	expectedEntitlements := []Entitlement{
 		// from test/env-repo-repo/environment/production/repositories/codespace-oddity/test-workflow.json
 		{
-			Environment: "production",
-			Workflow:    "Workflow 1",
+			Environment:        "production",
+			AllowAnyRepository: true,
+			Workflow:           "Workflow 1",
+			Source:             "test/env-repo-repo/environment/production/repositories/codespace-oddity/test-workflow.json",
 			Scopes: Scope{
 				Repositories: []string{
 					"codespace-oddity",
@@ -472,11 +459,7 @@ func TestEnvOwnersRepoConfig(t *testing.T) {
 
 	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
 
-	files, err := os.ReadDir(path)
-	if err != nil {
-		t.Error(err)
-	}
-	err = config.loadFolder(path, files, true)
+	err := config.loadFolder(os.DirFS("."), path, true)
 	if err != nil {
 		t.Error(err)
 	}
@@ -490,6 +473,7 @@ func TestEnvOwnersRepoConfig(t *testing.T) {
 			RepositoryOwner: "major-tom",
 			Environment:     "production",
 			Workflow:        "Workflow 1",
+			Source:          "test/env-owner-repo-repo/environment/production/owner/major-tom/repository/starman/test-workflow.json",
 			Scopes: Scope{
 				Permissions: github.InstallationPermissions{
 					Contents: &write,
@@ -510,11 +494,7 @@ func TestEnvOrganizationRepoConfig(t *testing.T) {
 
 	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
 
-	files, err := os.ReadDir(path)
-	if err != nil {
-		t.Error(err)
-	}
-	err = config.loadFolder(path, files, true)
+	err := config.loadFolder(os.DirFS("."), path, true)
 	if err != nil {
 		t.Error(err)
 	}
@@ -524,8 +504,10 @@ func TestEnvOrganizationRepoConfig(t *testing.T) {
 	expectedEntitlements := []Entitlement{
 		// from test/env-organization-repo/environment/production/organization/custom_roles/test-workflow.json
 		{
-			Environment: "production",
-			Workflow:    "Workflow 1",
+			Environment:        "production",
+			AllowAnyRepository: true,
+			Workflow:           "Workflow 1",
+			Source:             "test/env-organization-repo/environment/production/organization/custom_roles/write/test-workflow.json",
 			Scopes: Scope{
 				Permissions: github.InstallationPermissions{
 					OrganizationCustomRoles: &write,
@@ -540,3 +522,88 @@ func TestEnvOrganizationRepoConfig(t *testing.T) {
 		t.Errorf("Expected entitlements to be %s, but got %v", string(expectedEntitlementsJson), string(gotEntitlementsJson))
 	}
 }
+
+func TestStripAllPermissionsButUnknownPermission(t *testing.T) {
+	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
+	entitlement := Entitlement{}
+
+	err := config.stripAllPermissionsBut("organization_unknown", "read", &entitlement)
+	if err == nil {
+		t.Error("Expected an unknown permission to be rejected")
+	}
+}
+
+func TestInvalidRepoConfig(t *testing.T) {
+	path := "test/invalid-repo"
+
+	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
+
+	err := config.loadFolder(os.DirFS("."), path, true)
+	if err == nil || err.Error() != `test/invalid-repo/generic.json: unknown field "enviroment"` {
+		t.Errorf("Expected loading to fail on the misspelled field, but got %v", err)
+	}
+}
+
+func TestInvalidPermissionLevel(t *testing.T) {
+	_, err := decodeEntitlement("test.json", strings.NewReader(`{"repository_owner": "major-tom", "scopes": {"permissions": {"contents": "delete"}}}`))
+	if err == nil || err.Error() != "test.json: invalid level 'delete' for permission 'contents', expected one of read, write, admin" {
+		t.Errorf("Expected decoding to fail on the permission level, but got %v", err)
+	}
+}
+
+func TestUnknownPermission(t *testing.T) {
+	_, err := decodeEntitlement("test.json", strings.NewReader(`{"repository_owner": "major-tom", "scopes": {"permissions": {"contentz": "write"}}}`))
+	if err == nil || err.Error() != `test.json: unknown field "contentz"` {
+		t.Errorf("Expected decoding to fail on the permission name, but got %v", err)
+	}
+}
+
+func TestInvalidFileConfig(t *testing.T) {
+	content, err := os.ReadFile("test/invalid-file.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "invalid-file.json")
+	err = config.parseConfigFile(content)
+	if err == nil || err.Error() != `invalid-file.json[1]: unknown field "repository"` {
+		t.Errorf("Expected parsing to fail on the unknown field, but got %v", err)
+	}
+}
+
+func TestWildcardRepoConfig(t *testing.T) {
+	path := "test/wildcard-repo"
+
+	config := NewEntitlementConfig("test", 1, "https://github.com", "test", "")
+
+	err := config.loadFolder(os.DirFS("."), path, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	loadedSources := []string{}
+	for _, entitlement := range config.Entitlements {
+		loadedSources = append(loadedSources, entitlement.Source)
+	}
+	quarantinedSources := []string{}
+	for _, entitlement := range config.Quarantined {
+		quarantinedSources = append(quarantinedSources, entitlement.Source)
+	}
+
+	expectedLoadedSources := []string{
+		"test/wildcard-repo/environment/production/deny.json",
+		"test/wildcard-repo/environment/production/pinned-repository.json",
+		"test/wildcard-repo/owner/major-tom/pinned-owner.json",
+	}
+	expectedQuarantinedSources := []string{
+		"test/wildcard-repo/environment/production/glob-owner.json",
+		"test/wildcard-repo/environment/production/wildcard.json",
+	}
+
+	if !reflect.DeepEqual(expectedLoadedSources, loadedSources) {
+		t.Errorf("Expected loaded entitlements to be %v, but got %v", expectedLoadedSources, loadedSources)
+	}
+	if !reflect.DeepEqual(expectedQuarantinedSources, quarantinedSources) {
+		t.Errorf("Expected quarantined entitlements to be %v, but got %v", expectedQuarantinedSources, quarantinedSources)
+	}
+}
@@ -1,19 +1,34 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/bradleyfalzon/ghinstallation/v2"
 	"github.com/joho/godotenv"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidateCommand(os.Args[2:]))
+		case "simulate":
+			os.Exit(runSimulateCommand(os.Args[2:]))
+		}
+	}
+
 	godotenv.Load()
 	port := os.Getenv("PORT")
 	private_key_base64 := os.Getenv("PRIVATE_KEY")
@@ -46,37 +61,146 @@ func main() {
 		log.Printf("CONFIG_FILE set to '%s'", configFile)
 	}
 
-	appTransport, err := ghinstallation.NewAppsTransport(http.DefaultTransport, app_id, private_key)
+	// The configuration is loaded from the default branch of the config repository unless a branch or a tag is pinned
+	configRef := os.Getenv("CONFIG_REF")
+	if configRef != "" {
+		log.Printf("CONFIG_REF set to '%s'", configRef)
+	}
+
+	// The config repository is cloned unless it is read through the API, e.g. when git over HTTPS is blocked
+	configLoader := os.Getenv("CONFIG_LOADER")
+	switch configLoader {
+	case "":
+		configLoader = cloneConfigLoader
+	case cloneConfigLoader, apiConfigLoader:
+		log.Printf("CONFIG_LOADER set to '%s'", configLoader)
+	default:
+		log.Fatal("Invalid CONFIG_LOADER, expected 'clone' or 'api':", configLoader)
+	}
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		log.Fatal("Failed to initialize tracing:", err)
+	}
+
+	// Calls to the GitHub API, including the ones made by ghinstallation, are traced
+	appTransport, err := ghinstallation.NewAppsTransport(otelhttp.NewTransport(http.DefaultTransport), app_id, private_key)
 	if err != nil {
 		log.Fatal("Failed to initialize GitHub App transport:", err)
 	}
 
-	wellKnownURL := "https://token.actions.githubusercontent.com/.well-known/jwks"
 	gitUrl := "https://github.com"
-
 	if ghesUrl := os.Getenv("GHES_URL"); ghesUrl != "" {
 		appTransport.BaseURL = fmt.Sprintf("%s/api/v3", ghesUrl)
-		wellKnownURL = fmt.Sprintf("%s/_services/token/.well-known/jwks", ghesUrl)
 		gitUrl = ghesUrl
 	}
+	if issuerURLOverride := os.Getenv("ISSUER_URL"); issuerURLOverride != "" {
+		log.Printf("ISSUER_URL set to '%s'", issuerURLOverride)
+	}
+	issuerURL := gitHubIssuerFromEnv()
+
+	// GitHub is always trusted, other issuers such as GitLab CI or Buildkite can be added through a file
+	issuers := []*Issuer{}
+	if issuersFile := os.Getenv("ISSUERS_FILE"); issuersFile != "" {
+		log.Printf("ISSUERS_FILE set to '%s'", issuersFile)
+		issuers, err = loadIssuers(issuersFile)
+		if err != nil {
+			log.Fatal("Failed to load issuers:", err)
+		}
+	}
+	// The JWKS URL and the algorithms come from the OpenID configuration of the issuer,
+	// the well-known location of the JWKS is used if it can't be retrieved
+	gitHubIssuer := NewGitHubIssuer(issuerURL, fmt.Sprintf("%s/.well-known/jwks", issuerURL))
+	if err := gitHubIssuer.discover(); err != nil {
+		log.Printf("failed to discover issuer %s, using JWKS %s: %s", issuerURL, gitHubIssuer.JwksURL, err)
+	}
+	if audience := os.Getenv("AUDIENCE"); audience != "" {
+		log.Printf("AUDIENCE set to '%s'", audience)
+		for _, value := range strings.Split(audience, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				gitHubIssuer.Audiences = append(gitHubIssuer.Audiences, value)
+			}
+		}
+	} else {
+		log.Println("AUDIENCE is not set, OIDC tokens delivered for any audience will be accepted")
+	}
 
-	appContext := NewAppContext(time.Now(), appTransport, webhook_secret, configRepo, configFile, wellKnownURL, gitUrl)
+	leeway, err := parseLeeway(os.Getenv("TOKEN_LEEWAY"))
+	if err != nil {
+		log.Fatal("Invalid TOKEN_LEEWAY:", err)
+	}
+	gitHubIssuer.Leeway = leeway
+	for _, issuer := range issuers {
+		issuer.Leeway = leeway
+	}
 
-	fmt.Println("loading config cache")
-	err = appContext.loadConfigs()
+	authenticator := NewIssuerRegistry(gitHubIssuer, issuers...)
+	authenticator.StartJwksRefresh(context.Background())
+
+	// Each OIDC token can be exchanged once by default, 0 disables the replay protection
+	var replayCache ReplayCache
+	maxUses := 1
+	if maxUsesString := os.Getenv("TOKEN_MAX_USES"); maxUsesString != "" {
+		maxUses, err = strconv.Atoi(maxUsesString)
+		if err != nil || maxUses < 0 {
+			log.Fatal("Invalid TOKEN_MAX_USES:", maxUsesString)
+		}
+	}
+	if maxUses > 0 {
+		replayCache = NewMemoryReplayCache(maxUses)
+	} else {
+		log.Println("TOKEN_MAX_USES is set to 0, OIDC tokens can be replayed until they expire")
+	}
+
+	auditSink, err := newAuditSink(os.Getenv("AUDIT_LOG"))
 	if err != nil {
-		log.Println("error while loading config cache", err)
+		log.Fatal("Invalid AUDIT_LOG:", err)
 	}
 
+	appContext := NewAppContext(appTransport, webhook_secret, configRepo, configFile, configRef, configLoader, authenticator, replayCache, auditSink, gitUrl, issuerURL, os.Getenv("ADMIN_TOKEN"))
+
+	// The server starts right away, /readyz reports when the configurations are loaded
+	fmt.Println("loading config cache")
+	go appContext.loadInitialConfigs(30 * time.Second)
+
 	fmt.Printf("starting up on port %s\n", port)
 
 	server := http.Server{
 		Addr:         fmt.Sprintf(":%s", port),
-		Handler:      appContext,
+		Handler:      otelhttp.NewHandler(appContext, "github-oidc-auth-app"),
 		ReadTimeout:  60 * time.Second,
 		WriteTimeout: 60 * time.Second,
 	}
 
-	server.ListenAndServe()
+	// Stop accepting requests on SIGINT or SIGTERM, and let the requests in flight complete
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serverShutdown := make(chan struct{})
+	go func() {
+		defer close(serverShutdown)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println("failed to shut down the server:", err)
+		}
+	}()
+
+	serverErr := server.ListenAndServe()
+	if errors.Is(serverErr, http.ErrServerClosed) {
+		// ListenAndServe returns as soon as the shutdown starts
+		<-serverShutdown
+		serverErr = nil
+	}
 
+	// Export the pending spans before exiting
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Println("failed to shut down tracing:", err)
+	}
+	if serverErr != nil {
+		log.Println("server stopped:", serverErr)
+		os.Exit(1)
+	}
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"reflect"
 	"testing"
@@ -38,7 +39,7 @@ var claims jwt.MapClaims = jwt.MapClaims{
 	"workflow_sha":          "44216e5ae99f3653290b60b7f995bfe1c0f3aba0",
 }
 
-func TestExactRegexEntitlement(t *testing.T) {
+func TestExactEntitlementMatch(t *testing.T) {
 	entitlement := Entitlement{
 		Environment:       "production",
 		Repository:        "major-tom/starman",
@@ -66,23 +67,54 @@ func TestExactRegexEntitlement(t *testing.T) {
 		WorkflowRef:       "major-tom/starman/.github/workflows/manual-test.yml@refs/heads/main",
 		WorkflowSha:       "44216e5ae99f3653290b60b7f995bfe1c0f3aba0",
 	}
-	entitlementRegex := entitlement.regexString()
-	if entitlementRegex != "^actor:major-tom,actor_id:2787414,aud:api://ActionsOIDCGateway,base_ref:.*,environment:production,event_name:workflow_dispatch,head_ref:.*,iss:https://token.actions.githubusercontent.com,job_workflow_ref:major-tom/starman/.github/workflows/manual-test.yml@refs/heads/main,job_workflow_sha:44216e5ae99f3653290b60b7f995bfe1c0f3aba0,ref:refs/heads/main,ref_type:branch,repository:major-tom/starman,repository_id:630836305,repository_owner:major-tom,repository_owner_id:2787414,repository_visibility:public,run_attempt:1,run_id:4779904167,run_number:12,runner_environment:github-hosted,sub:repo:major-tom/starman:ref:refs/heads/main,workflow:Manual Test Workflow,workflow_ref:major-tom/starman/.github/workflows/manual-test.yml@refs/heads/main,workflow_sha:44216e5ae99f3653290b60b7f995bfe1c0f3aba0$" {
-		t.Error("Expected entitlementRegex to be ^actor:major-tom,actor_id:2787414,aud:api://ActionsOIDCGateway,base_ref:.*,environment:production,event_name:workflow_dispatch,head_ref:.*,iss:https://token.actions.githubusercontent.com,job_workflow_ref:major-tom/starman/.github/workflows/manual-test.yml@refs/heads/main,job_workflow_sha:44216e5ae99f3653290b60b7f995bfe1c0f3aba0,ref:refs/heads/main,ref_type:branch,repository:major-tom/starman,repository_id:630836305,repository_owner:major-tom,repository_owner_id:2787414,repository_visibility:public,run_attempt:1,run_id:4779904167,run_number:12,runner_environment:github-hosted,sub:repo:major-tom/starman:ref:refs/heads/main,workflow:Manual Test Workflow,workflow_ref:major-tom/starman/.github/workflows/manual-test.yml@refs/heads/main,workflow_sha:44216e5ae99f3653290b60b7f995bfe1c0f3aba0$, but got", entitlementRegex)
+	if len(entitlement.claimMatchers()) != 23 {
+		t.Errorf("Expected 23 claim matchers, but got %d", len(entitlement.claimMatchers()))
+	}
+	if !entitlement.matches(claims, gitHubIssuerURL) {
+		t.Errorf("Expected entitlement to match, but %s didn't", entitlement.firstMismatch(claims, gitHubIssuerURL))
+	}
+
+	entitlement.Ref = "refs/heads/mai"
+	mismatch := entitlement.firstMismatch(claims, gitHubIssuerURL)
+	if mismatch == nil || mismatch.Claim != "ref" {
+		t.Errorf("Expected ref claim to mismatch, but got %v", mismatch)
 	}
 }
 
-func TestFuzzyRegexEntitlement(t *testing.T) {
+func TestFuzzyEntitlementMatch(t *testing.T) {
 	entitlement := Entitlement{
 		Environment:     "production",
 		RepositoryOwner: "major-tom",
 		EventName:       "workflow_dispatch",
-		Ref:             "refs/heads/main",
+		Ref:             "refs/heads/*",
 		Visibility:      "public",
 	}
-	entitlementRegex := entitlement.regexString()
-	if entitlementRegex != "^actor:.*,actor_id:.*,aud:.*,base_ref:.*,environment:production,event_name:workflow_dispatch,head_ref:.*,iss:.*,job_workflow_ref:.*,job_workflow_sha:.*,ref:refs/heads/main,ref_type:.*,repository:.*,repository_id:.*,repository_owner:major-tom,repository_owner_id:.*,repository_visibility:public,run_attempt:.*,run_id:.*,run_number:.*,runner_environment:.*,sub:.*,workflow:.*,workflow_ref:.*,workflow_sha:.*$" {
-		t.Error("Expected entitlementRegex to be ^actor:.*,actor_id:.*,aud:.*,base_ref:.*,environment:production,event_name:workflow_dispatch,head_ref:.*,iss:.*,job_workflow_ref:.*,job_workflow_sha:.*,ref:refs/heads/main,ref_type:.*,repository:.*,repository_id:.*,repository_owner:major-tom,repository_owner_id:.*,repository_visibility:public,run_attempt:.*,run_id:.*,run_number:.*,runner_environment:.*,sub:.*,workflow:.*,workflow_ref:.*,workflow_sha:.*$, but got", entitlementRegex)
+	if !entitlement.matches(claims, gitHubIssuerURL) {
+		t.Errorf("Expected entitlement to match, but %s didn't", entitlement.firstMismatch(claims, gitHubIssuerURL))
+	}
+
+	entitlement.Ref = "regex:^refs/heads/(main|master)$"
+	if !entitlement.matches(claims, gitHubIssuerURL) {
+		t.Errorf("Expected entitlement to match, but %s didn't", entitlement.firstMismatch(claims, gitHubIssuerURL))
+	}
+
+	// This used to be interpreted as a regex
+	entitlement.Ref = "refs/heads/ma.*"
+	if entitlement.matches(claims, gitHubIssuerURL) {
+		t.Error("Expected entitlement to not match as values are literal by default")
+	}
+}
+
+func TestEntitlementMatchMissingClaim(t *testing.T) {
+	entitlement := Entitlement{
+		RepositoryOwner: "major-tom",
+		HeadRef:         "**",
+	}
+	partialClaims := jwt.MapClaims{
+		"repository_owner": "major-tom",
+	}
+	if entitlement.matches(partialClaims, gitHubIssuerURL) {
+		t.Error("Expected entitlement to not match when a claim is not set")
 	}
 }
 
@@ -193,3 +225,93 @@ func TestNoEntitlements(t *testing.T) {
 		t.Errorf("Expected scope.Permissions to be %s, but got %v", string(expPerms), string(gotPerms))
 	}
 }
+
+func TestComputeDenyEntitlements(t *testing.T) {
+	b, err := os.ReadFile("test/deny-match.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entitlements []Entitlement
+	err = json.Unmarshal(b, &entitlements)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entitlementConfig := &EntitlementConfig{
+		Entitlements: entitlements,
+	}
+
+	expectedRepoList := []string{"codespace-oddity"}
+	read := "read"
+	write := "write"
+	expectedPermissions := github.InstallationPermissions{
+		Contents: &read,
+		Checks:   &write,
+	}
+
+	// Compute the scope for the claims
+	scope := entitlementConfig.computeScopes(claims)
+	if !reflect.DeepEqual(scope.Repositories, expectedRepoList) {
+		t.Errorf("Expected scope.Repositories to be [codespace-oddity], but got %s", scope.Repositories)
+	}
+	if !reflect.DeepEqual(scope.Permissions, expectedPermissions) {
+		expPerms, _ := json.MarshalIndent(expectedPermissions, "", "  ")
+		gotPerms, _ := json.MarshalIndent(scope.Permissions, "", "  ")
+		t.Errorf("Expected scope.Permissions to be %s, but got %v", string(expPerms), string(gotPerms))
+	}
+
+	// The last deny entitlement revokes everything for pull requests
+	pullRequestClaims := jwt.MapClaims{}
+	for key, value := range claims {
+		pullRequestClaims[key] = value
+	}
+	pullRequestClaims["event_name"] = "pull_request"
+
+	scope = entitlementConfig.computeScopes(pullRequestClaims)
+	if !scope.isEmpty() {
+		t.Errorf("Expected scope to be empty, but got %s", scope.String())
+	}
+}
+
+func TestExplainEntitlements(t *testing.T) {
+	b, err := os.ReadFile("test/deny-match.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entitlements []Entitlement
+	err = json.Unmarshal(b, &entitlements)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index := range entitlements {
+		entitlements[index].Source = fmt.Sprintf("deny-match.json[%d]", index)
+	}
+
+	entitlementConfig := &EntitlementConfig{
+		Entitlements: entitlements,
+	}
+
+	explanations := entitlementConfig.explain(claims, true)
+	expectedExplanations := []EntitlementExplanation{
+		{Source: "deny-match.json[0]", Effect: "allow", Matched: true, Scopes: entitlements[0].Scopes},
+		{Source: "deny-match.json[1]", Effect: "deny", Matched: true, Scopes: entitlements[1].Scopes},
+		{Source: "deny-match.json[2]", Effect: "deny", Matched: true, Scopes: entitlements[2].Scopes},
+		{Source: "deny-match.json[3]", Effect: "deny", Matched: false, FailedClaim: "event_name", ExpectedValue: "pull_request", Scopes: entitlements[3].Scopes},
+	}
+
+	if !reflect.DeepEqual(explanations, expectedExplanations) {
+		expected, _ := json.MarshalIndent(expectedExplanations, "", "  ")
+		got, _ := json.MarshalIndent(explanations, "", "  ")
+		t.Errorf("Expected explanations to be %s, but got %s", string(expected), string(got))
+	}
+
+	// Only the matching entitlements are listed to the other callers
+	explanations = entitlementConfig.explain(claims, false)
+	if !reflect.DeepEqual(explanations, expectedExplanations[:3]) {
+		expected, _ := json.MarshalIndent(expectedExplanations[:3], "", "  ")
+		got, _ := json.MarshalIndent(explanations, "", "  ")
+		t.Errorf("Expected explanations to be %s, but got %s", string(expected), string(got))
+	}
+}
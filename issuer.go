@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Issuer of the OIDC tokens of GitHub Actions on github.com
+const gitHubIssuerURL = "https://token.actions.githubusercontent.com"
+
+/*
+ * Get the issuer of the OIDC tokens of GitHub Actions from the environment: GHES and GHE.com have their own issuer
+ */
+func gitHubIssuerFromEnv() string {
+	issuerURL := gitHubIssuerURL
+	if ghesUrl := os.Getenv("GHES_URL"); ghesUrl != "" {
+		issuerURL = fmt.Sprintf("%s/_services/token", ghesUrl)
+	}
+	if issuerURLOverride := os.Getenv("ISSUER_URL"); issuerURLOverride != "" {
+		issuerURL = strings.TrimSuffix(issuerURLOverride, "/")
+	}
+	return issuerURL
+}
+
+/*
+ * Check whether the claims come from a token delivered by another issuer than GitHub Actions
+ */
+func isOtherIssuer(claims jwt.MapClaims, gitHubIssuer string) bool {
+	issuer, found := claims["iss"]
+	return found && issuer != gitHubIssuer
+}
+
+// Clock skew tolerated when checking the exp, nbf and iat claims
+const defaultLeeway = 30 * time.Second
+const maxLeeway = 5 * time.Minute
+
+/*
+ * Validate an OIDC token and return its claims, ready to be matched against entitlements
+ */
+type Authenticator interface {
+	Authenticate(oidcTokenString string) (jwt.MapClaims, error)
+}
+
+/*
+ * A trusted OIDC issuer, e.g. GitHub Actions, GitLab CI or Buildkite
+ */
+type Issuer struct {
+	// Short name of the issuer, used in logs
+	Name string `json:"name"`
+	// Expected value of the iss claim
+	IssuerURL string `json:"issuer"`
+	// URL of the JSON Web Key Set used to verify the signature of the tokens, discovered from the OpenID configuration of the issuer when empty
+	JwksURL string `json:"jwks_url,omitempty"`
+	// Accepted signing algorithms, e.g. RS256. Any algorithm matching the type of the key is accepted when empty.
+	Algorithms []string `json:"algorithms,omitempty"`
+	// The aud claim of the token needs to contain one of these values. Any audience is accepted when empty.
+	Audiences []string `json:"audiences,omitempty"`
+	// Copy the value of an issuer specific claim to another claim, e.g. {"project_path": "repository"},
+	// so that the entitlements written for GitHub claims can be reused
+	ClaimMapping map[string]string `json:"claim_mapping,omitempty"`
+	// Clock skew tolerated when checking the exp, nbf and iat claims
+	Leeway time.Duration `json:"-"`
+
+	jwks *JwksCache
+}
+
+func NewGitHubIssuer(issuerURL string, jwksURL string) *Issuer {
+	return &Issuer{Name: "github", IssuerURL: issuerURL, JwksURL: jwksURL, Leeway: defaultLeeway, jwks: NewJwksCache(jwksURL)}
+}
+
+/*
+ * Check the token was signed by the issuer and return its claims once mapped
+ */
+func (issuer *Issuer) Authenticate(oidcTokenString string) (jwt.MapClaims, error) {
+	keyset, err := issuer.jwks.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	// Attempt to validate JWT with JWKS, exp, nbf and iat are checked if they are set
+	options := []jwt.ParserOption{jwt.WithLeeway(issuer.Leeway), jwt.WithIssuedAt()}
+	if issuer.IssuerURL != "" {
+		options = append(options, jwt.WithIssuer(issuer.IssuerURL))
+	}
+	if len(issuer.Algorithms) > 0 {
+		options = append(options, jwt.WithValidMethods(issuer.Algorithms))
+	}
+	oidcToken, err := jwt.Parse(oidcTokenString, getKeyFromKeyset(keyset), options...)
+	// The issuer might have rotated its keys since the last refresh
+	if errors.Is(err, errUnknownKid) && issuer.jwks.ForceRefresh() {
+		if keyset, err = issuer.jwks.Get(); err != nil {
+			return nil, err
+		}
+		oidcToken, err = jwt.Parse(oidcTokenString, getKeyFromKeyset(keyset), options...)
+	}
+	if err != nil || !oidcToken.Valid {
+		return nil, err
+	}
+
+	claims, ok := oidcToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("unable to map JWT claims")
+	}
+
+	// An OIDC token needs to expire
+	if expirationTime, err := claims.GetExpirationTime(); err != nil || expirationTime == nil {
+		return nil, fmt.Errorf("%w: exp", jwt.ErrTokenRequiredClaimMissing)
+	}
+
+	if !issuer.acceptsAudience(claims) {
+		return nil, fmt.Errorf("%w: %v is not accepted by issuer %s", jwt.ErrTokenInvalidAudience, claims["aud"], issuer.Name)
+	}
+
+	return issuer.mapClaims(claims), nil
+}
+
+func (issuer *Issuer) acceptsAudience(claims jwt.MapClaims) bool {
+	if len(issuer.Audiences) == 0 {
+		return true
+	}
+	for _, audience := range claimValues(claims, "aud") {
+		for _, acceptedAudience := range issuer.Audiences {
+			if audience == acceptedAudience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+ * Copy the issuer specific claims to the claims used by the entitlements. The original claims are kept.
+ */
+func (issuer *Issuer) mapClaims(claims jwt.MapClaims) jwt.MapClaims {
+	if len(issuer.ClaimMapping) == 0 {
+		return claims
+	}
+
+	mappedClaims := jwt.MapClaims{}
+	for claim, value := range claims {
+		mappedClaims[claim] = value
+	}
+	for sourceClaim, targetClaim := range issuer.ClaimMapping {
+		if value, ok := claims[sourceClaim]; ok {
+			mappedClaims[targetClaim] = value
+		}
+	}
+	return mappedClaims
+}
+
+/*
+ * Set of trusted issuers. A token is validated by the issuer matching its iss claim.
+ */
+type IssuerRegistry struct {
+	// Issuer used when no other issuer matches the iss claim of the token
+	defaultIssuer *Issuer
+	issuers       map[string]*Issuer
+}
+
+/*
+ * Refresh the JWKS of all the issuers in the background until the context is cancelled
+ */
+func (registry *IssuerRegistry) StartJwksRefresh(ctx context.Context) {
+	for _, issuer := range registry.issuers {
+		issuer.jwks.Start(ctx)
+	}
+}
+
+/*
+ * The registry is ready once the keys of every issuer have been retrieved
+ */
+func (registry *IssuerRegistry) Ready() error {
+	for _, issuer := range registry.issuers {
+		if !issuer.jwks.Loaded() {
+			return fmt.Errorf("JWKS of issuer %s is not loaded yet", issuer.Name)
+		}
+	}
+	return nil
+}
+
+func NewIssuerRegistry(defaultIssuer *Issuer, issuers ...*Issuer) *IssuerRegistry {
+	registry := &IssuerRegistry{defaultIssuer, make(map[string]*Issuer)}
+	registry.issuers[defaultIssuer.IssuerURL] = defaultIssuer
+	for _, issuer := range issuers {
+		registry.issuers[issuer.IssuerURL] = issuer
+	}
+	return registry
+}
+
+func (registry *IssuerRegistry) Authenticate(oidcTokenString string) (jwt.MapClaims, error) {
+	// The signature is checked afterwards by the issuer, we only need the iss claim to select it
+	unverifiedToken, _, err := jwt.NewParser().ParseUnverified(oidcTokenString, jwt.MapClaims{})
+	if err != nil {
+		return nil, err
+	}
+
+	issuerURL, _ := unverifiedToken.Claims.GetIssuer()
+	issuer, ok := registry.issuers[issuerURL]
+	if !ok {
+		issuer = registry.defaultIssuer
+	}
+	return issuer.Authenticate(oidcTokenString)
+}
+
+/*
+ * Short reason of a token validation failure, safe to return to the caller
+ */
+func describeTokenError(err error) string {
+	reasons := []struct {
+		err    error
+		reason string
+	}{
+		{jwt.ErrTokenExpired, "token is expired"},
+		{jwt.ErrTokenNotValidYet, "token is not valid yet"},
+		{jwt.ErrTokenUsedBeforeIssued, "token used before issued"},
+		{jwt.ErrTokenInvalidIssuer, "token has invalid issuer"},
+		{jwt.ErrTokenInvalidAudience, "token has invalid audience"},
+		{jwt.ErrTokenRequiredClaimMissing, "token is missing required claim"},
+		{jwt.ErrTokenSignatureInvalid, "token signature is invalid"},
+		{jwt.ErrTokenMalformed, "token is malformed"},
+		{jwt.ErrTokenUnverifiable, "token is unverifiable"},
+	}
+	for _, reason := range reasons {
+		if errors.Is(err, reason.err) {
+			return reason.reason
+		}
+	}
+	return "token is invalid"
+}
+
+/*
+ * Parse the clock skew leeway, which can't exceed maxLeeway
+ */
+func parseLeeway(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultLeeway, nil
+	}
+	leeway, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if leeway < 0 || leeway > maxLeeway {
+		return 0, fmt.Errorf("leeway needs to be between 0 and %s", maxLeeway)
+	}
+	return leeway, nil
+}
+
+/*
+ * Load the additional trusted issuers from a JSON file containing an array of issuers
+ */
+func loadIssuers(path string) ([]*Issuer, error) {
+	issuersFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer issuersFile.Close()
+
+	var issuers []*Issuer
+	decoder := json.NewDecoder(issuersFile)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&issuers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse issuers file %s: %w", path, err)
+	}
+
+	for _, issuer := range issuers {
+		if issuer.Name == "" || issuer.IssuerURL == "" {
+			return nil, fmt.Errorf("issuers defined in %s need a name and an issuer", path)
+		}
+		// Without an audience, a token delivered by this issuer to any other service could be used here
+		if len(issuer.Audiences) == 0 {
+			return nil, fmt.Errorf("issuer %s defined in %s needs at least one audience", issuer.Name, path)
+		}
+		issuer.Leeway = defaultLeeway
+		if issuer.JwksURL == "" {
+			if err := issuer.discover(); err != nil {
+				return nil, fmt.Errorf("failed to discover issuer %s defined in %s: %w", issuer.Name, path, err)
+			}
+		} else {
+			issuer.jwks = NewJwksCache(issuer.JwksURL)
+		}
+		log.Printf("trusting issuer %s (%s)", issuer.Name, issuer.IssuerURL)
+	}
+	return issuers, nil
+}
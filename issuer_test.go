@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+ * Create an issuer trusting a newly generated key, and return the key to sign tokens
+ */
+func newTestIssuer(t *testing.T, name string, issuerURL string) (*Issuer, *rsa.PrivateKey) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk := JWK{Kty: "RSA", Kid: name, Alg: "RS256", Use: "sig"}
+	jwk.N = base64.RawURLEncoding.EncodeToString(privateKey.PublicKey.N.Bytes())
+	jwk.E = "AQAB"
+	jwksBytes, _ := json.Marshal(JWKS{Keys: []JWK{jwk}})
+
+	issuer := &Issuer{Name: name, IssuerURL: issuerURL, jwks: NewJwksCache("")}
+	issuer.jwks.update(mustParseJwks(t, jwksBytes), -1)
+	return issuer, privateKey
+}
+
+/*
+ * Sign a token with the claims, which expires in 5 minutes unless exp is set. A nil exp signs a token which doesn't expire.
+ */
+func signTestToken(t *testing.T, privateKey *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	if exp, ok := claims["exp"]; !ok {
+		claims["exp"] = time.Now().Add(5 * time.Minute).Unix()
+	} else if exp == nil {
+		delete(claims, "exp")
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signedToken, err := token.SignedString(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signedToken
+}
+
+func TestIssuerRegistrySelectsIssuer(t *testing.T) {
+	gitHubIssuer, gitHubKey := newTestIssuer(t, "github", gitHubIssuerURL)
+	gitLabIssuer, gitLabKey := newTestIssuer(t, "gitlab", "https://gitlab.com")
+	registry := NewIssuerRegistry(gitHubIssuer, gitLabIssuer)
+
+	claims, err := registry.Authenticate(signTestToken(t, gitLabKey, "gitlab", jwt.MapClaims{"iss": "https://gitlab.com", "project_path": "major-tom/starman"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["project_path"] != "major-tom/starman" {
+		t.Error("Unable to find claims")
+	}
+
+	claims, err = registry.Authenticate(signTestToken(t, gitHubKey, "github", jwt.MapClaims{"iss": gitHubIssuerURL, "repository": "major-tom/starman"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["repository"] != "major-tom/starman" {
+		t.Error("Unable to find claims")
+	}
+
+	// A token claiming to come from GitLab can't be signed by another issuer
+	_, err = registry.Authenticate(signTestToken(t, gitHubKey, "github", jwt.MapClaims{"iss": "https://gitlab.com"}))
+	if err == nil {
+		t.Error("Should not validate token signed by another issuer")
+	}
+}
+
+func TestIssuerAudience(t *testing.T) {
+	issuer, privateKey := newTestIssuer(t, "gitlab", "https://gitlab.com")
+	issuer.Audiences = []string{"https://oidc-auth-app.example.com"}
+
+	_, err := issuer.Authenticate(signTestToken(t, privateKey, "gitlab", jwt.MapClaims{"iss": "https://gitlab.com", "aud": "https://oidc-auth-app.example.com"}))
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = issuer.Authenticate(signTestToken(t, privateKey, "gitlab", jwt.MapClaims{"iss": "https://gitlab.com", "aud": []string{"https://other.example.com", "https://oidc-auth-app.example.com"}}))
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = issuer.Authenticate(signTestToken(t, privateKey, "gitlab", jwt.MapClaims{"iss": "https://gitlab.com", "aud": "https://other.example.com"}))
+	if !errors.Is(err, jwt.ErrTokenInvalidAudience) {
+		t.Errorf("Should not validate token for another audience, got %v", err)
+	}
+
+	_, err = issuer.Authenticate(signTestToken(t, privateKey, "gitlab", jwt.MapClaims{"iss": "https://gitlab.com"}))
+	if err == nil {
+		t.Error("Should not validate token without audience")
+	}
+}
+
+func TestIssuerTimeClaims(t *testing.T) {
+	issuer, privateKey := newTestIssuer(t, "github", gitHubIssuerURL)
+	issuer.Leeway = 30 * time.Second
+	now := time.Now()
+
+	testCases := []struct {
+		name   string
+		claims jwt.MapClaims
+		err    error
+	}{
+		{"valid", jwt.MapClaims{"iss": gitHubIssuerURL, "nbf": now.Add(-time.Minute).Unix(), "iat": now.Add(-time.Minute).Unix()}, nil},
+		{"expired", jwt.MapClaims{"iss": gitHubIssuerURL, "exp": now.Add(-time.Minute).Unix()}, jwt.ErrTokenExpired},
+		{"expired within leeway", jwt.MapClaims{"iss": gitHubIssuerURL, "exp": now.Add(-10 * time.Second).Unix()}, nil},
+		{"not valid yet", jwt.MapClaims{"iss": gitHubIssuerURL, "nbf": now.Add(time.Minute).Unix()}, jwt.ErrTokenNotValidYet},
+		{"not valid yet within leeway", jwt.MapClaims{"iss": gitHubIssuerURL, "nbf": now.Add(10 * time.Second).Unix()}, nil},
+		{"issued in the future", jwt.MapClaims{"iss": gitHubIssuerURL, "iat": now.Add(time.Minute).Unix()}, jwt.ErrTokenUsedBeforeIssued},
+		{"without expiration", jwt.MapClaims{"iss": gitHubIssuerURL, "exp": nil}, jwt.ErrTokenRequiredClaimMissing},
+		{"other issuer", jwt.MapClaims{"iss": "https://token.actions.example.com"}, jwt.ErrTokenInvalidIssuer},
+		{"without issuer", jwt.MapClaims{}, jwt.ErrTokenRequiredClaimMissing},
+	}
+
+	for _, testCase := range testCases {
+		_, err := issuer.Authenticate(signTestToken(t, privateKey, "github", testCase.claims))
+		if testCase.err == nil && err != nil {
+			t.Errorf("%s: expected token to be valid, got %v", testCase.name, err)
+		}
+		if testCase.err != nil && !errors.Is(err, testCase.err) {
+			t.Errorf("%s: expected error %v, got %v", testCase.name, testCase.err, err)
+		}
+	}
+}
+
+func TestDescribeTokenError(t *testing.T) {
+	issuer, privateKey := newTestIssuer(t, "github", gitHubIssuerURL)
+	_, err := issuer.Authenticate(signTestToken(t, privateKey, "github", jwt.MapClaims{"iss": gitHubIssuerURL, "exp": time.Now().Add(-time.Hour).Unix()}))
+	if reason := describeTokenError(err); reason != "token is expired" {
+		t.Errorf("Expected expired token reason, got '%s'", reason)
+	}
+
+	_, err = issuer.Authenticate("not-a-token")
+	if reason := describeTokenError(err); reason != "token is malformed" {
+		t.Errorf("Expected malformed token reason, got '%s'", reason)
+	}
+}
+
+func TestParseLeeway(t *testing.T) {
+	if leeway, err := parseLeeway(""); err != nil || leeway != defaultLeeway {
+		t.Errorf("Expected default leeway, got %s, %v", leeway, err)
+	}
+	if leeway, err := parseLeeway("1m"); err != nil || leeway != time.Minute {
+		t.Errorf("Expected 1m leeway, got %s, %v", leeway, err)
+	}
+	if _, err := parseLeeway("1h"); err == nil {
+		t.Error("Expected leeway above the maximum to be rejected")
+	}
+	if _, err := parseLeeway("-1s"); err == nil {
+		t.Error("Expected negative leeway to be rejected")
+	}
+}
+
+func TestIssuerClaimMapping(t *testing.T) {
+	issuer, privateKey := newTestIssuer(t, "gitlab", "https://gitlab.com")
+	issuer.ClaimMapping = map[string]string{"project_path": "repository", "namespace_path": "repository_owner"}
+
+	claims, err := issuer.Authenticate(signTestToken(t, privateKey, "gitlab", jwt.MapClaims{
+		"iss":             "https://gitlab.com",
+		"project_path":    "major-tom/starman",
+		"namespace_path":  "major-tom",
+		"pipeline_source": "push",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entitlement := Entitlement{
+		Issuer:          "https://gitlab.com",
+		Repository:      "major-tom/starman",
+		RepositoryOwner: "major-tom",
+		Claims:          map[string]string{"pipeline_source": "push"},
+	}
+	if !entitlement.matches(claims, gitHubIssuerURL) {
+		t.Errorf("Expected entitlement to match, but %s didn't", entitlement.firstMismatch(claims, gitHubIssuerURL))
+	}
+	if claims["project_path"] != "major-tom/starman" {
+		t.Error("Expected original claims to be kept")
+	}
+
+	// The entitlements written for GitHub don't match the mapped claims of another issuer
+	gitHubEntitlement := Entitlement{RepositoryOwner: "major-tom"}
+	if gitHubEntitlement.matches(claims, gitHubIssuerURL) {
+		t.Error("Expected entitlement without iss not to match the claims of another issuer")
+	}
+	if !gitHubEntitlement.matches(jwt.MapClaims{"iss": gitHubIssuerURL, "repository_owner": "major-tom"}, gitHubIssuerURL) {
+		t.Error("Expected entitlement without iss to match the claims of GitHub")
+	}
+
+	// The mapped claims only identify a repository for a single issuer
+	entitlement.Issuer = "https://*"
+	if entitlement.isPinned() {
+		t.Error("Expected entitlement with a glob iss not to be pinned")
+	}
+}
+
+func TestLoadIssuers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "issuers.json")
+
+	os.WriteFile(path, []byte(`[{"name": "gitlab", "issuer": "https://gitlab.com", "jwks_url": "https://gitlab.com/oauth/discovery/keys", "audiences": ["https://oidc-auth-app.example.com"], "claim_mapping": {"project_path": "repository"}}]`), 0600)
+	issuers, err := loadIssuers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issuers) != 1 || issuers[0].ClaimMapping["project_path"] != "repository" {
+		t.Errorf("Expected one issuer with a claim mapping, but got %v", issuers)
+	}
+
+	os.WriteFile(path, []byte(`[{"name": "gitlab", "issuer": "https://gitlab.com", "jwks_url": "https://gitlab.com/oauth/discovery/keys"}]`), 0600)
+	_, err = loadIssuers(path)
+	if err == nil {
+		t.Error("Expected issuer without audience to be rejected")
+	}
+}
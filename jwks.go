@@ -1,24 +1,31 @@
 package main
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"log"
 	"math/big"
-	"net/http"
-	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+var errUnknownKid = errors.New("unknown kid")
+
 type JWK struct {
 	N   string
 	Kty string
 	Kid string
 	Alg string
 	E   string
+	Crv string
+	X   string
+	Y   string
 	Use string
 	X5c []string
 	X5t string
@@ -28,113 +35,100 @@ type JWKS struct {
 	Keys []JWK
 }
 
-func getKeyFromJwks(jwksBytes []byte) func(*jwt.Token) (interface{}, error) {
-	return func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
+/*
+ * Public keys of a JWKS, indexed by kid
+ */
+type Keyset map[string]crypto.PublicKey
+
+/*
+ * Decode the keys of a JWKS. Keys which aren't signing keys or which can't be decoded are skipped.
+ */
+func parseJwks(jwksBytes []byte) (Keyset, error) {
+	var jwks JWKS
+	if err := json.Unmarshal(jwksBytes, &jwks); err != nil {
+		return nil, fmt.Errorf("unable to parse JWKS: %w", err)
+	}
 
-		var jwks JWKS
-		if err := json.Unmarshal(jwksBytes, &jwks); err != nil {
-			return nil, fmt.Errorf("unable to parse JWKS")
+	keyset := Keyset{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
 		}
-
-		for _, jwk := range jwks.Keys {
-			if jwk.Kid == token.Header["kid"] {
-				nBytes, err := base64.RawURLEncoding.DecodeString(jwk.N)
-				if err != nil {
-					return nil, fmt.Errorf("unable to parse key")
-				}
-				var n big.Int
-
-				eBytes, err := base64.RawURLEncoding.DecodeString(jwk.E)
-				if err != nil {
-					return nil, fmt.Errorf("unable to parse key")
-				}
-				var e big.Int
-
-				key := rsa.PublicKey{
-					N: n.SetBytes(nBytes),
-					E: int(e.SetBytes(eBytes).Uint64()),
-				}
-
-				return &key, nil
-			}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("skipping key %s of JWKS: %s", jwk.Kid, err)
+			continue
 		}
-
-		return nil, fmt.Errorf("unknown kid: %v", token.Header["kid"])
+		keyset[jwk.Kid] = key
 	}
+	return keyset, nil
 }
 
-func validateTokenCameFromGitHub(oidcTokenString string, appCtxt *AppContext) (jwt.MapClaims, error) {
-	// Check if we have a recently cached JWKS
-	now := time.Now()
-
-	if now.Sub(appCtxt.jwksLastUpdate) > time.Minute || len(appCtxt.jwksCache) == 0 {
-		resp, err := http.Get(appCtxt.wellKnownURL)
+func (jwk JWK) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		nBytes, err := base64.RawURLEncoding.DecodeString(jwk.N)
 		if err != nil {
-			fmt.Println(err)
-			return nil, fmt.Errorf("unable to get JWKS configuration")
+			return nil, fmt.Errorf("unable to parse key")
 		}
-
-		jwksBytes, err := ioutil.ReadAll(resp.Body)
+		eBytes, err := base64.RawURLEncoding.DecodeString(jwk.E)
 		if err != nil {
-			fmt.Println(err)
-			return nil, fmt.Errorf("unable to get JWKS configuration")
+			return nil, fmt.Errorf("unable to parse key")
 		}
-
-		appCtxt.jwksCache = jwksBytes
-		appCtxt.jwksLastUpdate = now
-	}
-
-	// Attempt to validate JWT with JWKS
-
-	oidcToken, err := jwt.Parse(string(oidcTokenString), getKeyFromJwks(appCtxt.jwksCache))
-	if err != nil || !oidcToken.Valid {
-		return nil, err
-	}
-
-	claims, ok := oidcToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, fmt.Errorf("unable to map JWT claims")
+		var n, e big.Int
+		return &rsa.PublicKey{N: n.SetBytes(nBytes), E: int(e.SetBytes(eBytes).Uint64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		xBytes, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse key")
+		}
+		yBytes, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse key")
+		}
+		var x, y big.Int
+		key := &ecdsa.PublicKey{Curve: curve, X: x.SetBytes(xBytes), Y: y.SetBytes(yBytes)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("point is not on curve %s", jwk.Crv)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
 	}
-
-	return claims, nil
 }
 
-func stringifyMapClaims(claims jwt.MapClaims) string {
-	return "actor:" + claimFieldValue(claims["actor"]) +
-		",actor_id:" + claimFieldValue(claims["actor_id"]) +
-		",aud:" + claimFieldValue(claims["aud"]) +
-		",base_ref:" + claimFieldValue(claims["base_ref"]) +
-		",environment:" + claimFieldValue(claims["environment"]) +
-		",event_name:" + claimFieldValue(claims["event_name"]) +
-		",head_ref:" + claimFieldValue(claims["head_ref"]) +
-		",iss:" + claimFieldValue(claims["iss"]) +
-		",job_workflow_ref:" + claimFieldValue(claims["job_workflow_ref"]) +
-		",job_workflow_sha:" + claimFieldValue(claims["job_workflow_sha"]) +
-		",ref:" + claimFieldValue(claims["ref"]) +
-		",ref_type:" + claimFieldValue(claims["ref_type"]) +
-		",repository:" + claimFieldValue(claims["repository"]) +
-		",repository_id:" + claimFieldValue(claims["repository_id"]) +
-		",repository_owner:" + claimFieldValue(claims["repository_owner"]) +
-		",repository_owner_id:" + claimFieldValue(claims["repository_owner_id"]) +
-		",repository_visibility:" + claimFieldValue(claims["repository_visibility"]) +
-		",run_attempt:" + claimFieldValue(claims["run_attempt"]) +
-		",run_id:" + claimFieldValue(claims["run_id"]) +
-		",run_number:" + claimFieldValue(claims["run_number"]) +
-		",runner_environment:" + claimFieldValue(claims["runner_environment"]) +
-		",sub:" + claimFieldValue(claims["sub"]) +
-		",workflow:" + claimFieldValue(claims["workflow"]) +
-		",workflow_ref:" + claimFieldValue(claims["workflow_ref"]) +
-		",workflow_sha:" + claimFieldValue(claims["workflow_sha"])
-}
+/*
+ * Find the key which signed the token. The signing method needs to match the type of the key.
+ */
+func getKeyFromKeyset(keyset Keyset) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keyset[kid]
+		if !ok {
+			return nil, fmt.Errorf("%w: %v", errUnknownKid, token.Header["kid"])
+		}
 
-func claimFieldValue(value interface{}) string {
-	if value == nil {
-		// This is to make sure we have a consistent representation of the claim for the regex matching
-		return "XXXNOTSETXXX"
-	} else {
-		return fmt.Sprint(value)
+		switch key.(type) {
+		case *rsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodRSA); ok {
+				return key, nil
+			}
+		case *ecdsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodECDSA); ok {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 	}
 }
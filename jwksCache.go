@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Refresh interval used when the JWKS response doesn't set a Cache-Control max-age
+const defaultJwksRefreshInterval = 5 * time.Minute
+
+// Bounds of the refresh interval, whatever the max-age is
+const minJwksRefreshInterval = time.Minute
+const maxJwksRefreshInterval = time.Hour
+
+// Delay before retrying a failed refresh
+const jwksRetryInterval = 10 * time.Second
+
+// Minimum delay between two refreshes forced by a token signed with an unknown key
+const minJwksForcedRefreshInterval = 30 * time.Second
+
+var maxAgeRegex = regexp.MustCompile(`(?i)(?:^|,)\s*max-age\s*=\s*"?(\d+)"?`)
+
+/*
+ * Cache of the JSON Web Key Set of an issuer, decoded once per refresh. The keyset is refreshed in the background and the last good
+ * keyset keeps being served when a refresh fails, so that an outage of the issuer doesn't block token requests.
+ */
+type JwksCache struct {
+	// Issuer whose OpenID configuration is read again to find the new URL of the JWKS when a refresh fails, if discovered
+	issuerURL         string
+	url               string
+	client            *http.Client
+	keyset            Keyset
+	lastUpdate        time.Time
+	refreshInterval   time.Duration
+	lastForcedRefresh time.Time
+	mu                sync.RWMutex
+	refreshMu         sync.Mutex
+	now               func() time.Time
+}
+
+func NewJwksCache(url string) *JwksCache {
+	return &JwksCache{
+		url:             url,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		refreshInterval: defaultJwksRefreshInterval,
+		now:             time.Now,
+	}
+}
+
+/*
+ * Get the cached keyset. It is fetched synchronously only if it has never been retrieved.
+ */
+func (cache *JwksCache) Get() (Keyset, error) {
+	cache.mu.RLock()
+	keyset := cache.keyset
+	cache.mu.RUnlock()
+	if keyset != nil {
+		return keyset, nil
+	}
+
+	if err := cache.Refresh(); err != nil {
+		return nil, err
+	}
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	return cache.keyset, nil
+}
+
+/*
+ * Check whether a keyset has been retrieved
+ */
+func (cache *JwksCache) Loaded() bool {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	return cache.keyset != nil
+}
+
+/*
+ * Fetch the keyset. The last good keyset is kept when the fetch fails.
+ */
+func (cache *JwksCache) Refresh() error {
+	// Concurrent refreshes would only fetch the same keyset
+	cache.refreshMu.Lock()
+	defer cache.refreshMu.Unlock()
+
+	keyset, maxAge, err := cache.fetch()
+	recordJwksRefresh(cache.url, keyset, err)
+	if err != nil && cache.rediscover() {
+		keyset, maxAge, err = cache.fetch()
+		recordJwksRefresh(cache.url, keyset, err)
+	}
+	if err != nil {
+		return err
+	}
+	cache.update(keyset, maxAge)
+	return nil
+}
+
+func (cache *JwksCache) fetch() (Keyset, time.Duration, error) {
+	resp, err := cache.client.Get(cache.url)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to get JWKS from %s: %w", cache.url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unable to get JWKS from %s: status %d", cache.url, resp.StatusCode)
+	}
+
+	jwksBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to read JWKS from %s: %w", cache.url, err)
+	}
+
+	keyset, err := parseJwks(jwksBytes)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid JWKS from %s: %w", cache.url, err)
+	}
+	// An issuer always has a signing key, an empty keyset would reject all the tokens until the next refresh
+	if len(keyset) == 0 {
+		return nil, 0, fmt.Errorf("invalid JWKS from %s: no signing key", cache.url)
+	}
+	return keyset, parseMaxAge(resp.Header.Get("Cache-Control")), nil
+}
+
+/*
+ * Read the OpenID configuration of the issuer again and return true if the JWKS moved to another URL
+ */
+func (cache *JwksCache) rediscover() bool {
+	if cache.issuerURL == "" {
+		return false
+	}
+	configuration, err := fetchOpenIDConfiguration(cache.client, cache.issuerURL)
+	if err != nil {
+		log.Printf("failed to discover issuer %s again: %s", cache.issuerURL, err)
+		return false
+	}
+	if configuration.JwksURI == cache.url {
+		return false
+	}
+	log.Printf("JWKS of issuer %s moved from %s to %s", cache.issuerURL, cache.url, configuration.JwksURI)
+	cache.url = configuration.JwksURI
+	return true
+}
+
+/*
+ * Refresh the keyset when a token is signed with an unknown key, e.g. right after a key rotation.
+ * Forced refreshes are rate limited so that tokens with random kids can't be used to flood the issuer.
+ * Return true if the keyset has been refreshed.
+ */
+func (cache *JwksCache) ForceRefresh() bool {
+	cache.mu.Lock()
+	now := cache.now()
+	if now.Sub(cache.lastForcedRefresh) < minJwksForcedRefreshInterval {
+		cache.mu.Unlock()
+		return false
+	}
+	cache.lastForcedRefresh = now
+	cache.mu.Unlock()
+
+	if err := cache.Refresh(); err != nil {
+		log.Println("forced JWKS refresh failed:", err)
+		return false
+	}
+	return true
+}
+
+/*
+ * Refresh the keyset in the background until the context is cancelled
+ */
+func (cache *JwksCache) Start(ctx context.Context) {
+	go func() {
+		for {
+			delay := jwksRetryInterval
+			if err := cache.Refresh(); err != nil {
+				log.Println("JWKS refresh failed, serving the last good keyset:", err)
+			} else {
+				cache.mu.RLock()
+				delay = cache.refreshInterval
+				cache.mu.RUnlock()
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+	}()
+}
+
+func (cache *JwksCache) update(keyset Keyset, maxAge time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.keyset = keyset
+	cache.lastUpdate = cache.now()
+	cache.refreshInterval = boundRefreshInterval(maxAge)
+}
+
+/*
+ * Read the max-age directive of a Cache-Control header, -1 if there is none
+ */
+func parseMaxAge(cacheControl string) time.Duration {
+	match := maxAgeRegex.FindStringSubmatch(cacheControl)
+	if match == nil {
+		return -1
+	}
+	seconds, err := strconv.Atoi(match[1])
+	if err != nil {
+		return -1
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func boundRefreshInterval(maxAge time.Duration) time.Duration {
+	if maxAge < 0 {
+		return defaultJwksRefreshInterval
+	}
+	if maxAge < minJwksRefreshInterval {
+		return minJwksRefreshInterval
+	}
+	if maxAge > maxJwksRefreshInterval {
+		return maxJwksRefreshInterval
+	}
+	return maxAge
+}
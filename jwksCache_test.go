@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+ * Serve a JWKS which can be changed or made unavailable by the test
+ */
+type testJwksServer struct {
+	jwks         []byte
+	cacheControl string
+	failing      bool
+	requests     int
+	mu           sync.Mutex
+}
+
+func (server *testJwksServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.requests++
+	if server.failing {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	if server.cacheControl != "" {
+		w.Header().Set("Cache-Control", server.cacheControl)
+	}
+	w.Write(server.jwks)
+}
+
+func testJwks(t *testing.T, kid string) ([]byte, *rsa.PrivateKey) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := JWK{Kty: "RSA", Kid: kid, Alg: "RS256", Use: "sig"}
+	jwk.N = base64.RawURLEncoding.EncodeToString(privateKey.PublicKey.N.Bytes())
+	jwk.E = "AQAB"
+	jwksBytes, _ := json.Marshal(JWKS{Keys: []JWK{jwk}})
+	return jwksBytes, privateKey
+}
+
+func TestJwksCacheServesLastGoodKeyset(t *testing.T) {
+	jwksBytes, _ := testJwks(t, "good")
+	jwksServer := &testJwksServer{jwks: jwksBytes, cacheControl: "public, max-age=600"}
+	server := httptest.NewServer(jwksServer)
+	defer server.Close()
+
+	cache := NewJwksCache(server.URL)
+	if err := cache.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if cache.refreshInterval != 10*time.Minute {
+		t.Errorf("Expected refresh interval to follow max-age, got %s", cache.refreshInterval)
+	}
+
+	jwksServer.failing = true
+	if err := cache.Refresh(); err == nil {
+		t.Error("Expected refresh to fail")
+	}
+	keyset, err := cache.Get()
+	if err != nil || keyset == nil {
+		t.Errorf("Expected last good keyset, got %v, %v", keyset, err)
+	}
+}
+
+func TestJwksCacheKeepsKeysetOnInvalidJwks(t *testing.T) {
+	jwksBytes, _ := testJwks(t, "good")
+	jwksServer := &testJwksServer{jwks: jwksBytes}
+	server := httptest.NewServer(jwksServer)
+	defer server.Close()
+
+	cache := NewJwksCache(server.URL)
+	if err := cache.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	jwksServer.jwks = []byte("<html>maintenance</html>")
+	if err := cache.Refresh(); err == nil {
+		t.Error("Expected refresh to fail with an invalid JWKS")
+	}
+	keyset, _ := cache.Get()
+	if _, ok := keyset["good"]; !ok {
+		t.Error("Expected last good keyset to be kept")
+	}
+
+	jwksServer.jwks = []byte(`{"keys": []}`)
+	if err := cache.Refresh(); err == nil {
+		t.Error("Expected refresh to fail with an empty JWKS")
+	}
+	keyset, _ = cache.Get()
+	if _, ok := keyset["good"]; !ok {
+		t.Error("Expected last good keyset to be kept")
+	}
+}
+
+func TestJwksCacheForceRefreshIsRateLimited(t *testing.T) {
+	jwksBytes, _ := testJwks(t, "good")
+	jwksServer := &testJwksServer{jwks: jwksBytes}
+	server := httptest.NewServer(jwksServer)
+	defer server.Close()
+
+	now := time.Now()
+	cache := NewJwksCache(server.URL)
+	cache.now = func() time.Time { return now }
+
+	if !cache.ForceRefresh() {
+		t.Error("Expected first forced refresh to happen")
+	}
+	if cache.ForceRefresh() {
+		t.Error("Expected second forced refresh to be rate limited")
+	}
+	now = now.Add(minJwksForcedRefreshInterval)
+	if !cache.ForceRefresh() {
+		t.Error("Expected forced refresh to happen once the interval has elapsed")
+	}
+	if jwksServer.requests != 2 {
+		t.Errorf("Expected 2 requests, got %d", jwksServer.requests)
+	}
+}
+
+func TestIssuerRefreshesJwksOnUnknownKid(t *testing.T) {
+	oldJwks, _ := testJwks(t, "old")
+	newJwks, newKey := testJwks(t, "new")
+	jwksServer := &testJwksServer{jwks: oldJwks}
+	server := httptest.NewServer(jwksServer)
+	defer server.Close()
+
+	issuer := NewGitHubIssuer(gitHubIssuerURL, server.URL)
+	if err := issuer.jwks.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The issuer rotates its keys
+	jwksServer.jwks = newJwks
+	_, err := issuer.Authenticate(signTestToken(t, newKey, "new", jwt.MapClaims{"iss": gitHubIssuerURL}))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseMaxAge(t *testing.T) {
+	testCases := map[string]time.Duration{
+		"":                          -1,
+		"no-cache":                  -1,
+		"max-age=120":               2 * time.Minute,
+		"public, max-age=3600":      time.Hour,
+		"public, s-maxage=10":       -1,
+		"private, MAX-AGE=60, must": time.Minute,
+	}
+	for cacheControl, expected := range testCases {
+		if maxAge := parseMaxAge(cacheControl); maxAge != expected {
+			t.Errorf("Expected %s for '%s', got %s", expected, cacheControl, maxAge)
+		}
+	}
+
+	if interval := boundRefreshInterval(0); interval != minJwksRefreshInterval {
+		t.Errorf("Expected max-age=0 to be bounded to %s, got %s", minJwksRefreshInterval, interval)
+	}
+	if interval := boundRefreshInterval(48 * time.Hour); interval != maxJwksRefreshInterval {
+		t.Errorf("Expected long max-age to be bounded to %s, got %s", maxJwksRefreshInterval, interval)
+	}
+}
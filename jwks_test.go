@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/base64"
@@ -11,6 +13,14 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+func mustParseJwks(t *testing.T, jwksBytes []byte) Keyset {
+	keyset, err := parseJwks(jwksBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyset
+}
+
 func TestGetKeyForTokenMaker(t *testing.T) {
 	// Create a JWKS for verifying tokens
 	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
@@ -27,10 +37,10 @@ func TestGetKeyForTokenMaker(t *testing.T) {
 	jwks := JWKS{Keys: []JWK{jwk}}
 
 	jwksBytes, _ := json.Marshal(jwks)
-	getKeyFunc := getKeyFromJwks(jwksBytes)
+	getKeyFunc := getKeyFromKeyset(mustParseJwks(t, jwksBytes))
 
 	// Test token referencing known key
-	tokenClaims := jwt.MapClaims{"for": "testing"}
+	tokenClaims := jwt.MapClaims{"for": "testing", "exp": time.Now().Add(5 * time.Minute).Unix()}
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, tokenClaims)
 
 	token.Header["kid"] = "testKey"
@@ -52,6 +62,7 @@ func TestGetKeyForTokenMaker(t *testing.T) {
 
 	// Test token fails with any other signing key than RSA
 	tokenHmac := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims)
+	tokenHmac.Header["kid"] = "testKey"
 
 	key, err = getKeyFunc(tokenHmac)
 	if err == nil {
@@ -59,7 +70,50 @@ func TestGetKeyForTokenMaker(t *testing.T) {
 	}
 }
 
-func TestValidateTokenCameFromGitHub(t *testing.T) {
+func TestGetECKeyFromKeyset(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk := JWK{Kty: "EC", Kid: "ecKey", Alg: "ES256", Use: "sig", Crv: "P-256"}
+	jwk.X = base64.RawURLEncoding.EncodeToString(privateKey.PublicKey.X.FillBytes(make([]byte, 32)))
+	jwk.Y = base64.RawURLEncoding.EncodeToString(privateKey.PublicKey.Y.FillBytes(make([]byte, 32)))
+	// Encryption keys and unsupported key types are skipped
+	jwksBytes, _ := json.Marshal(JWKS{Keys: []JWK{jwk, {Kty: "RSA", Kid: "encKey", Use: "enc"}, {Kty: "OKP", Kid: "edKey"}}})
+
+	keyset := mustParseJwks(t, jwksBytes)
+	if len(keyset) != 1 {
+		t.Errorf("Expected only the EC key to be kept, got %d keys", len(keyset))
+	}
+
+	issuer := &Issuer{Name: "github", jwks: NewJwksCache("")}
+	issuer.jwks.update(keyset, -1)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"for": "testing", "exp": time.Now().Add(5 * time.Minute).Unix()})
+	token.Header["kid"] = "ecKey"
+	signedToken, err := token.SignedString(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := issuer.Authenticate(signedToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["for"] != "testing" {
+		t.Error("Unable to find claims")
+	}
+
+	// An EC key can't verify a token claiming to be signed with RSA
+	rsaToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{})
+	rsaToken.Header["kid"] = "ecKey"
+	if _, err := getKeyFromKeyset(keyset)(rsaToken); err == nil {
+		t.Error("Should fail when the signing method doesn't match the key type")
+	}
+}
+
+func TestIssuerAuthenticate(t *testing.T) {
 	// Create a JWKS for verifying tokens
 	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
@@ -75,10 +129,11 @@ func TestValidateTokenCameFromGitHub(t *testing.T) {
 	jwks := JWKS{Keys: []JWK{jwk}}
 	jwksBytes, _ := json.Marshal(jwks)
 
-	gatewayContext := &AppContext{jwksCache: jwksBytes, jwksLastUpdate: time.Now()}
+	issuer := &Issuer{Name: "github", jwks: NewJwksCache("")}
+	issuer.jwks.update(mustParseJwks(t, jwksBytes), -1)
 
 	// Test token signed in the expected way
-	tokenClaims := jwt.MapClaims{"for": "testing"}
+	tokenClaims := jwt.MapClaims{"for": "testing", "exp": time.Now().Add(5 * time.Minute).Unix()}
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, tokenClaims)
 	token.Header["kid"] = "testKey"
 
@@ -87,7 +142,7 @@ func TestValidateTokenCameFromGitHub(t *testing.T) {
 		panic(err)
 	}
 
-	claims, err := validateTokenCameFromGitHub(signedToken, gatewayContext)
+	claims, err := issuer.Authenticate(signedToken)
 
 	if err != nil {
 		t.Error(err)
@@ -107,7 +162,7 @@ func TestValidateTokenCameFromGitHub(t *testing.T) {
 		panic(err)
 	}
 
-	claims, err = validateTokenCameFromGitHub(signedToken, gatewayContext)
+	claims, err = issuer.Authenticate(signedToken)
 	if err == nil {
 		t.Error("Should not validate token signed with other key")
 	}
@@ -118,60 +173,8 @@ func TestValidateTokenCameFromGitHub(t *testing.T) {
 
 	noneToken, err := token.SignedString("none signing method allowed")
 
-	claims, err = validateTokenCameFromGitHub(noneToken, gatewayContext)
+	claims, err = issuer.Authenticate(noneToken)
 	if err == nil {
 		t.Error("Should not validate unsigned token")
 	}
 }
-
-func TestStringifyCompleteMapClaims(t *testing.T) {
-	claims := jwt.MapClaims{
-		"actor":                 "helaili",
-		"actor_id":              2787414,
-		"aud":                   "api://ActionsOIDCGateway",
-		"base_ref":              "refs/heads/main",
-		"environment":           "production",
-		"event_name":            "workflow_dispatch",
-		"head_ref":              "refs/heads/main",
-		"iss":                   "https://token.actions.githubusercontent.com",
-		"job_workflow_ref":      "helaili/github-oidc-auth-app/.github/workflows/manual-test.yml@refs/heads/main",
-		"job_workflow_sha":      "44216e5ae99f3653290b60b7f995bfe1c0f3aba0",
-		"ref":                   "refs/heads/main",
-		"ref_type":              "branch",
-		"repository":            "helaili/github-oidc-auth-app",
-		"repository_id":         630836305,
-		"repository_owner":      "helaili",
-		"repository_owner_id":   2787414,
-		"repository_visibility": "public",
-		"run_attempt":           1,
-		"run_id":                4779904167,
-		"run_number":            12,
-		"runner_environment":    "github-hosted",
-		"sub":                   "repo:helaili/github-oidc-auth-app:ref:refs/heads/main",
-		"workflow":              "Manual Test Workflow",
-		"workflow_ref":          "helaili/github-oidc-auth-app/.github/workflows/manual-test.yml@refs/heads/main",
-		"workflow_sha":          "44216e5ae99f3653290b60b7f995bfe1c0f3aba0",
-	}
-
-	strMapClaims := stringifyMapClaims(claims)
-	if strMapClaims != "actor:helaili,actor_id:2787414,aud:api://ActionsOIDCGateway,base_ref:refs/heads/main,environment:production,event_name:workflow_dispatch,head_ref:refs/heads/main,iss:https://token.actions.githubusercontent.com,job_workflow_ref:helaili/github-oidc-auth-app/.github/workflows/manual-test.yml@refs/heads/main,job_workflow_sha:44216e5ae99f3653290b60b7f995bfe1c0f3aba0,ref:refs/heads/main,ref_type:branch,repository:helaili/github-oidc-auth-app,repository_id:630836305,repository_owner:helaili,repository_owner_id:2787414,repository_visibility:public,run_attempt:1,run_id:4779904167,run_number:12,runner_environment:github-hosted,sub:repo:helaili/github-oidc-auth-app:ref:refs/heads/main,workflow:Manual Test Workflow,workflow_ref:helaili/github-oidc-auth-app/.github/workflows/manual-test.yml@refs/heads/main,workflow_sha:44216e5ae99f3653290b60b7f995bfe1c0f3aba0" {
-		t.Error("Expected stringified map claims to be actor:helaili,actor_id:2787414,aud:api://ActionsOIDCGateway,base_ref:refs/heads/main,environment:production,event_name:workflow_dispatch,head_ref:refs/heads/main,iss:https://token.actions.githubusercontent.com,job_workflow_ref:helaili/github-oidc-auth-app/.github/workflows/manual-test.yml@refs/heads/main,job_workflow_sha:44216e5ae99f3653290b60b7f995bfe1c0f3aba0,ref:refs/heads/main,ref_type:branch,repository:helaili/github-oidc-auth-app,repository_id:630836305,repository_owner:helaili,repository_owner_id:2787414,repository_visibility:public,run_attempt:1,run_id:4779904167,run_number:12,runner_environment:github-hosted,sub:repo:helaili/github-oidc-auth-app:ref:refs/heads/main,workflow:Manual Test Workflow,workflow_ref:helaili/github-oidc-auth-app/.github/workflows/manual-test.yml@refs/heads/main,workflow_sha:44216e5ae99f3653290b60b7f995bfe1c0f3aba0, but got", strMapClaims)
-	}
-}
-
-func TestStringifyPartialMapClaims(t *testing.T) {
-	claims := jwt.MapClaims{
-		"actor":                 "helaili",
-		"event_name":            "workflow_dispatch",
-		"ref":                   "refs/heads/main",
-		"repository":            "helaili/github-oidc-auth-app",
-		"repository_owner":      "helaili",
-		"repository_visibility": "public",
-		"workflow":              "Manual Test Workflow",
-	}
-
-	strMapClaims := stringifyMapClaims(claims)
-	if strMapClaims != "actor:helaili,actor_id:XXXNOTSETXXX,aud:XXXNOTSETXXX,base_ref:XXXNOTSETXXX,environment:XXXNOTSETXXX,event_name:workflow_dispatch,head_ref:XXXNOTSETXXX,iss:XXXNOTSETXXX,job_workflow_ref:XXXNOTSETXXX,job_workflow_sha:XXXNOTSETXXX,ref:refs/heads/main,ref_type:XXXNOTSETXXX,repository:helaili/github-oidc-auth-app,repository_id:XXXNOTSETXXX,repository_owner:helaili,repository_owner_id:XXXNOTSETXXX,repository_visibility:public,run_attempt:XXXNOTSETXXX,run_id:XXXNOTSETXXX,run_number:XXXNOTSETXXX,runner_environment:XXXNOTSETXXX,sub:XXXNOTSETXXX,workflow:Manual Test Workflow,workflow_ref:XXXNOTSETXXX,workflow_sha:XXXNOTSETXXX" {
-		t.Error("Expected stringified map claims to be actor:helaili,actor_id:XXXNOTSETXXX,aud:XXXNOTSETXXX,base_ref:XXXNOTSETXXX,environment:XXXNOTSETXXX,event_name:workflow_dispatch,head_ref:XXXNOTSETXXX,iss:XXXNOTSETXXX,job_workflow_ref:XXXNOTSETXXX,job_workflow_sha:XXXNOTSETXXX,ref:refs/heads/main,ref_type:XXXNOTSETXXX,repository:helaili/github-oidc-auth-app,repository_id:XXXNOTSETXXX,repository_owner:helaili,repository_owner_id:XXXNOTSETXXX,repository_visibility:public,run_attempt:XXXNOTSETXXX,run_id:XXXNOTSETXXX,run_number:XXXNOTSETXXX,runner_environment:XXXNOTSETXXX,sub:XXXNOTSETXXX,workflow:Manual Test Workflow,workflow_ref:XXXNOTSETXXX,workflow_sha:XXXNOTSETXXX, but got", strMapClaims)
-	}
-}
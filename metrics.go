@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "oidc_auth"
+
+var (
+	tokenRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "token_requests_total",
+		Help:      "Number of token requests by outcome.",
+	}, []string{"outcome"})
+
+	oidcValidationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "oidc_validation_duration_seconds",
+		Help:      "Time spent validating OIDC tokens.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	createInstallationTokenDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "github_create_installation_token_duration_seconds",
+		Help:      "Time spent creating installation tokens with the GitHub API.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	configReloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_reloads_total",
+		Help:      "Number of configuration reloads by login.",
+	}, []string{"login"})
+
+	configReloadFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_reload_failures_total",
+		Help:      "Number of failed configuration reloads by login.",
+	}, []string{"login"})
+
+	entitlementsLoaded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "entitlements_loaded",
+		Help:      "Number of entitlements loaded by installation.",
+	}, []string{"login", "installation_id"})
+
+	configStale = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_stale",
+		Help:      "1 when the last reload of the configuration failed and the last configuration which loaded is still in use, by login.",
+	}, []string{"login"})
+
+	jwksRefreshesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "jwks_refreshes_total",
+		Help:      "Number of JWKS refreshes by JWKS URL and result.",
+	}, []string{"jwks_url", "result"})
+
+	jwksLastSuccessTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "jwks_last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful JWKS refresh by JWKS URL.",
+	}, []string{"jwks_url"})
+
+	jwksKeys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "jwks_keys",
+		Help:      "Number of keys in the cached JWKS by JWKS URL.",
+	}, []string{"jwks_url"})
+)
+
+var metricsHandler = promhttp.Handler()
+
+func init() {
+	prometheus.MustRegister(tokenRequestsTotal, oidcValidationDuration, createInstallationTokenDuration,
+		configReloadsTotal, configReloadFailuresTotal, entitlementsLoaded, configStale,
+		jwksRefreshesTotal, jwksLastSuccessTimestamp, jwksKeys)
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+/*
+ * Record the duration of an operation in a histogram, labelled with the result of the operation
+ */
+func observeDuration(histogram *prometheus.HistogramVec, start time.Time, err error) {
+	histogram.WithLabelValues(resultLabel(err)).Observe(time.Since(start).Seconds())
+}
+
+/*
+ * Record a reload, the gauges describing the configuration in use which could be the previous one when the reload failed
+ */
+func recordConfigReload(config *EntitlementConfig, err error) {
+	configReloadsTotal.WithLabelValues(config.Login).Inc()
+	if err != nil {
+		configReloadFailuresTotal.WithLabelValues(config.Login).Inc()
+	}
+	entitlementsLoaded.DeletePartialMatch(prometheus.Labels{"login": config.Login})
+	entitlementsLoaded.WithLabelValues(config.Login, strconv.FormatInt(config.InstallationId, 10)).Set(float64(len(config.Entitlements)))
+	if config.ReloadError != "" {
+		configStale.WithLabelValues(config.Login).Set(1)
+	} else {
+		configStale.WithLabelValues(config.Login).Set(0)
+	}
+}
+
+func recordJwksRefresh(url string, keyset Keyset, err error) {
+	jwksRefreshesTotal.WithLabelValues(url, resultLabel(err)).Inc()
+	if err == nil {
+		jwksLastSuccessTimestamp.WithLabelValues(url).SetToCurrentTime()
+		jwksKeys.WithLabelValues(url).Set(float64(len(keyset)))
+	}
+}
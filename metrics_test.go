@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTokenRequestMetrics(t *testing.T) {
+	context := AppContext{authenticator: NewIssuerRegistry(NewGitHubIssuer(gitHubIssuerURL, ""))}
+	before := testutil.ToFloat64(tokenRequestsTotal.WithLabelValues(OutcomeInvalidOIDC))
+
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(`{"login": "octodemo", "oidcToken": "not-a-token"}`))
+	context.handleTokenRequest(httptest.NewRecorder(), req)
+
+	if after := testutil.ToFloat64(tokenRequestsTotal.WithLabelValues(OutcomeInvalidOIDC)); after != before+1 {
+		t.Errorf("Expected invalid-oidc counter to be incremented, got %f", after)
+	}
+}
+
+func TestConfigReloadMetrics(t *testing.T) {
+	config := NewEntitlementConfig("metrics-test", 42, "https://github.com", "oidc_entitlements", "")
+	config.Entitlements = []Entitlement{{RepositoryOwner: "major-tom"}, {RepositoryOwner: "ziggy"}}
+
+	recordConfigReload(config, nil)
+	recordConfigReload(config, errors.New("repository not found"))
+
+	if reloads := testutil.ToFloat64(configReloadsTotal.WithLabelValues("metrics-test")); reloads != 2 {
+		t.Errorf("Expected 2 reloads, got %f", reloads)
+	}
+	if failures := testutil.ToFloat64(configReloadFailuresTotal.WithLabelValues("metrics-test")); failures != 1 {
+		t.Errorf("Expected 1 failure, got %f", failures)
+	}
+	if loaded := testutil.ToFloat64(entitlementsLoaded.WithLabelValues("metrics-test", "42")); loaded != 2 {
+		t.Errorf("Expected 2 entitlements loaded, got %f", loaded)
+	}
+
+	// The previous configuration is still in use after a failed reload
+	config.ReloadError = "repository not found"
+	recordConfigReload(config, errors.New("repository not found"))
+	if stale := testutil.ToFloat64(configStale.WithLabelValues("metrics-test")); stale != 1 {
+		t.Errorf("Expected the configuration to be stale, got %f", stale)
+	}
+	if loaded := testutil.ToFloat64(entitlementsLoaded.WithLabelValues("metrics-test", "42")); loaded != 2 {
+		t.Errorf("Expected the 2 entitlements in use to be reported, got %f", loaded)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	recordJwksRefresh("https://token.actions.githubusercontent.com/.well-known/jwks", Keyset{"key": nil}, nil)
+
+	context := AppContext{}
+	recorder := httptest.NewRecorder()
+	context.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body, _ := io.ReadAll(recorder.Body)
+	if recorder.Code != http.StatusOK || !strings.Contains(string(body), `oidc_auth_jwks_keys{jwks_url="https://token.actions.githubusercontent.com/.well-known/jwks"} 1`) {
+		t.Errorf("Expected JWKS metrics to be exposed, got %d %s", recorder.Code, body)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Name of the policy file at the root of the config repository
+const policyFileName = "policy.json"
+
+/*
+ * Installation level policy, applied before any entitlement is evaluated
+ */
+type Policy struct {
+	Comment string `json:"comment,omitempty"`
+	// Ids of the owners (organizations or users) of the repositories whose workflows are allowed to request a token from this installation
+	AllowedRepositoryOwnerIds []int64 `json:"allowed_repository_owner_ids"`
+	// Issuers other than GitHub Actions whose tokens are allowed, their claims can't be checked against the GitHub owner ids
+	AllowedIssuers []string `json:"allowed_issuers,omitempty"`
+}
+
+/*
+ * Strictly decode a policy file
+ */
+func decodePolicy(source string, reader io.Reader) (*Policy, error) {
+	var policy Policy
+
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&policy)
+	if err != nil {
+		return nil, ValidationIssue{Source: source, Message: strings.TrimPrefix(err.Error(), "json: ")}
+	}
+	if policy.AllowedRepositoryOwnerIds == nil {
+		return nil, ValidationIssue{Source: source, Message: "missing field \"allowed_repository_owner_ids\""}
+	}
+	return &policy, nil
+}
+
+/*
+ * Load the policy from a file of the config repository. A missing file means there is no policy.
+ */
+func (config *EntitlementConfig) loadPolicyFile(fsys fs.FS, path string) error {
+	policyFile, err := fsys.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("couldn't open file %s: %s", path, err)
+		return err
+	}
+	defer policyFile.Close()
+
+	policy, err := decodePolicy(path, policyFile)
+	if err != nil {
+		log.Printf("failed to parse policy file %s: %s", path, err)
+		return err
+	}
+	config.Policy = policy
+	return nil
+}
+
+/*
+ * Check that the owner of the repository running the workflow is allowed to request a token. Everyone is allowed when there is no policy.
+ * The repository_owner_id claim is only trusted in the tokens of gitHubIssuer.
+ */
+func (policy *Policy) allows(claims jwt.MapClaims, gitHubIssuer string) bool {
+	if policy == nil {
+		return true
+	}
+
+	// The claims of another issuer could be mapped to repository_owner_id, they are not GitHub ids
+	if isOtherIssuer(claims, gitHubIssuer) {
+		for _, allowedIssuer := range policy.AllowedIssuers {
+			if claims["iss"] == allowedIssuer {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, ownerId := range claimValues(claims, "repository_owner_id") {
+		for _, allowedOwnerId := range policy.AllowedRepositoryOwnerIds {
+			if ownerId == strconv.FormatInt(allowedOwnerId, 10) {
+				return true
+			}
+		}
+	}
+	return false
+}
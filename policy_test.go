@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestNoPolicyAllowsEveryone(t *testing.T) {
+	var policy *Policy
+	if !policy.allows(jwt.MapClaims{"repository_owner_id": "1"}, gitHubIssuerURL) {
+		t.Error("Expected everyone to be allowed when there is no policy")
+	}
+}
+
+func TestPolicyAllows(t *testing.T) {
+	policy := &Policy{AllowedRepositoryOwnerIds: []int64{2787414}}
+
+	if !policy.allows(jwt.MapClaims{"repository_owner_id": "2787414"}, gitHubIssuerURL) {
+		t.Error("Expected string owner id to be allowed")
+	}
+	if !policy.allows(jwt.MapClaims{"repository_owner_id": float64(2787414)}, gitHubIssuerURL) {
+		t.Error("Expected numeric owner id to be allowed")
+	}
+	if policy.allows(jwt.MapClaims{"repository_owner_id": "1"}, gitHubIssuerURL) {
+		t.Error("Expected other owner id to be denied")
+	}
+	if policy.allows(jwt.MapClaims{"repository_owner": "major-tom"}, gitHubIssuerURL) {
+		t.Error("Expected missing owner id to be denied")
+	}
+}
+
+func TestPolicyOtherIssuers(t *testing.T) {
+	policy := &Policy{AllowedRepositoryOwnerIds: []int64{2787414}}
+	gitLabClaims := jwt.MapClaims{"iss": "https://gitlab.com", "repository_owner_id": "2787414"}
+
+	if !policy.allows(jwt.MapClaims{"iss": gitHubIssuerURL, "repository_owner_id": "2787414"}, gitHubIssuerURL) {
+		t.Error("Expected GitHub owner id to be allowed")
+	}
+	if policy.allows(gitLabClaims, gitHubIssuerURL) {
+		t.Error("Expected mapped owner id of another issuer to be denied")
+	}
+	policy.AllowedIssuers = []string{"https://gitlab.com"}
+	if !policy.allows(gitLabClaims, gitHubIssuerURL) {
+		t.Error("Expected allowed issuer to be allowed")
+	}
+}
+
+func TestEmptyPolicyDeniesEveryone(t *testing.T) {
+	policy, err := decodePolicy("policy.json", strings.NewReader(`{"allowed_repository_owner_ids": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.allows(jwt.MapClaims{"repository_owner_id": "2787414"}, gitHubIssuerURL) {
+		t.Error("Expected everyone to be denied with an empty list")
+	}
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	_, err := decodePolicy("policy.json", strings.NewReader(`{"allowed_owner_ids": [2787414]}`))
+	if err == nil || err.Error() != `policy.json: unknown field "allowed_owner_ids"` {
+		t.Errorf("Expected unknown field to be rejected, but got %v", err)
+	}
+
+	_, err = decodePolicy("policy.json", strings.NewReader(`{}`))
+	if err == nil || err.Error() != `policy.json: missing field "allowed_repository_owner_ids"` {
+		t.Errorf("Expected missing field to be rejected, but got %v", err)
+	}
+}
+
+func TestPolicyRepoConfig(t *testing.T) {
+	config, err := loadLocalConfig("test/policy-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.Entitlements) != 1 || config.Entitlements[0].Source != "test/policy-repo/owner/major-tom/contents.json" {
+		t.Errorf("Expected the policy file to not be loaded as an entitlement, but got %v", config.Entitlements)
+	}
+	if config.Policy == nil || !reflect.DeepEqual(config.Policy.AllowedRepositoryOwnerIds, []int64{2787414}) {
+		t.Errorf("Expected policy to be loaded, but got %v", config.Policy)
+	}
+
+	issues := validateConfig("test/policy-repo")
+	if len(issues) != 0 {
+		t.Errorf("Expected no issue, but got %v", issues)
+	}
+}
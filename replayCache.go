@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+ * Track how many times an OIDC token has been exchanged, so that a leaked token can't be replayed.
+ * Implementations shared between replicas, e.g. backed by Redis, can be plugged in for multi-replica deployments.
+ */
+type ReplayCache interface {
+	// Record a use of the token identified by key and return false when it has already been used too many times.
+	// The record isn't needed after expiresAt, as the token isn't valid anymore.
+	Use(key string, expiresAt time.Time) (bool, error)
+	// Give back a use recorded by Use, when no scoped token has been issued for it.
+	Release(key string) error
+}
+
+type replayEntry struct {
+	uses      int
+	expiresAt time.Time
+}
+
+/*
+ * In-memory replay cache, only suitable when a single replica of the app is running
+ */
+type MemoryReplayCache struct {
+	maxUses   int
+	cache     map[string]*replayEntry
+	lastPurge time.Time
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+func NewMemoryReplayCache(maxUses int) *MemoryReplayCache {
+	return &MemoryReplayCache{maxUses: maxUses, cache: make(map[string]*replayEntry), now: time.Now}
+}
+
+func (rc *MemoryReplayCache) Use(key string, expiresAt time.Time) (bool, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	now := rc.now()
+	// Expired tokens are rejected anyway, no need to remember them
+	if now.Sub(rc.lastPurge) > time.Minute {
+		for cachedKey, entry := range rc.cache {
+			if now.After(entry.expiresAt) {
+				delete(rc.cache, cachedKey)
+			}
+		}
+		rc.lastPurge = now
+	}
+
+	entry, ok := rc.cache[key]
+	if !ok || now.After(entry.expiresAt) {
+		entry = &replayEntry{expiresAt: expiresAt}
+		rc.cache[key] = entry
+	}
+	if entry.uses >= rc.maxUses {
+		return false, nil
+	}
+	entry.uses++
+	return true, nil
+}
+
+func (rc *MemoryReplayCache) Release(key string) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if entry, ok := rc.cache[key]; ok && entry.uses > 0 {
+		entry.uses--
+	}
+	return nil
+}
+
+/*
+ * Identify an OIDC token in the replay cache. The token needs a jti claim to be tracked.
+ */
+func replayKey(claims jwt.MapClaims) (string, error) {
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return "", fmt.Errorf("%w: jti", jwt.ErrTokenRequiredClaimMissing)
+	}
+	// jti is only unique for a given issuer
+	return fmt.Sprintf("%v|%s", claims["iss"], jti), nil
+}
+
+/*
+ * Record a use of an authenticated OIDC token. The use is recorded before the token is exchanged so that concurrent requests
+ * can't exceed the maximum number of uses, and is given back with releaseToken when no scoped token is issued.
+ */
+func useToken(replayCache ReplayCache, claims jwt.MapClaims) (bool, error) {
+	key, err := replayKey(claims)
+	if err != nil {
+		return false, err
+	}
+	expirationTime, err := claims.GetExpirationTime()
+	if err != nil || expirationTime == nil {
+		return false, fmt.Errorf("%w: exp", jwt.ErrTokenRequiredClaimMissing)
+	}
+	// Keep the record while the token can still be accepted thanks to the leeway
+	return replayCache.Use(key, expirationTime.Add(maxLeeway))
+}
+
+/*
+ * Give back the use of an OIDC token recorded by useToken
+ */
+func releaseToken(replayCache ReplayCache, claims jwt.MapClaims) error {
+	key, err := replayKey(claims)
+	if err != nil {
+		return err
+	}
+	return replayCache.Release(key)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestMemoryReplayCache(t *testing.T) {
+	now := time.Now()
+	replayCache := NewMemoryReplayCache(2)
+	replayCache.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := replayCache.Use("token-1", now.Add(time.Minute)); !allowed {
+			t.Errorf("Expected use %d to be allowed", i+1)
+		}
+	}
+	if allowed, _ := replayCache.Use("token-1", now.Add(time.Minute)); allowed {
+		t.Error("Expected third use to be rejected")
+	}
+	if allowed, _ := replayCache.Use("token-2", now.Add(time.Minute)); !allowed {
+		t.Error("Expected another token to be allowed")
+	}
+	// A use given back can be made again
+	replayCache.Release("token-1")
+	if allowed, _ := replayCache.Use("token-1", now.Add(time.Minute)); !allowed {
+		t.Error("Expected released use to be allowed")
+	}
+
+	// Entries are dropped once the token has expired
+	now = now.Add(2 * time.Minute)
+	replayCache.Use("token-3", now.Add(time.Minute))
+	if _, ok := replayCache.cache["token-1"]; ok {
+		t.Error("Expected expired entry to be purged")
+	}
+}
+
+func TestUseToken(t *testing.T) {
+	replayCache := NewMemoryReplayCache(1)
+	exp := float64(time.Now().Add(5 * time.Minute).Unix())
+
+	if allowed, err := useToken(replayCache, jwt.MapClaims{"iss": gitHubIssuerURL, "jti": "abc", "exp": exp}); err != nil || !allowed {
+		t.Errorf("Expected first use to be allowed, got %v", err)
+	}
+	if allowed, _ := useToken(replayCache, jwt.MapClaims{"iss": gitHubIssuerURL, "jti": "abc", "exp": exp}); allowed {
+		t.Error("Expected replay to be rejected")
+	}
+	// jti is only unique for a given issuer
+	if allowed, _ := useToken(replayCache, jwt.MapClaims{"iss": "https://gitlab.com", "jti": "abc", "exp": exp}); !allowed {
+		t.Error("Expected same jti from another issuer to be allowed")
+	}
+	if _, err := useToken(replayCache, jwt.MapClaims{"iss": gitHubIssuerURL, "exp": exp}); err == nil {
+		t.Error("Expected token without jti to be rejected")
+	}
+}
+
+func TestTokenRequestReplay(t *testing.T) {
+	issuer, privateKey := newTestIssuer(t, "github", gitHubIssuerURL)
+	context := AppContext{
+		authenticator:     issuer,
+		replayCache:       NewMemoryReplayCache(1),
+		configCache:       NewConfigCache(),
+		installationCache: NewInstallationCache(),
+	}
+	context.configCache.SetConfig("octodemo", NewEntitlementConfig("octodemo", 1, "https://github.com", "oidc_entitlements", ""))
+	body := `{"login": "octodemo", "oidcToken": "` + signTestToken(t, privateKey, "github", jwt.MapClaims{"iss": gitHubIssuerURL, "jti": "abc"}) + `"}`
+
+	// No token is issued without an installation, so the use is given back and the token can be exchanged again
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		context.handleTokenRequest(recorder, httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(body)))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("Expected request %d to succeed, but got %d", i+1, recorder.Code)
+		}
+	}
+
+	// A token which has already been exchanged is rejected
+	useToken(context.replayCache, jwt.MapClaims{"iss": gitHubIssuerURL, "jti": "abc", "exp": float64(time.Now().Add(5 * time.Minute).Unix())})
+	recorder := httptest.NewRecorder()
+	context.handleTokenRequest(recorder, httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(body)))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected replayed request to be rejected, but got %d", recorder.Code)
+	}
+}
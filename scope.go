@@ -67,15 +67,17 @@ func (scope *Scope) String() string {
 	return fmt.Sprintf("{repositories: [%s], permissions: {%s}}", strings.Join(scope.Repositories, ", "), strings.Join(kvPairs, ", "))
 }
 
+var permissionRank = map[string]int{
+	"read":  0,
+	"write": 1,
+	"admin": 2,
+}
+
+var permissionLevels = []string{"read", "write", "admin"}
+
 func (cumulativeScope *Scope) merge(additionalScope Scope) {
 	cumulativeScope.Repositories = append(cumulativeScope.Repositories, additionalScope.Repositories...)
 
-	permissionRank := map[string]int{
-		"read":  0,
-		"write": 1,
-		"admin": 2,
-	}
-
 	// Get the list of fields from the struct github.InstallationPermissions
 	fields := reflect.VisibleFields(reflect.TypeOf(struct{ github.InstallationPermissions }{}))
 
@@ -108,3 +110,146 @@ func (cumulativeScope *Scope) merge(additionalScope Scope) {
 		}
 	}
 }
+
+/*
+ * Remove a denied scope from the cumulative scope and return a description of what was removed.
+ * - an empty denied scope revokes the whole scope.
+ * - denied repositories are removed from the list of repositories. As an empty list of repositories means all the repositories
+ *   of the installation, repository permissions are removed when no repository is left or when the cumulative scope was not restricted to a list of repositories.
+ * - a denied permission removes this level of permission and above, e.g. denying contents:write caps contents to read, denying contents:read removes contents.
+ * - denied permissions together with denied repositories only concern these repositories. As the permissions of a token apply to all of its repositories,
+ *   the denied repositories are removed when the scope grants one of the denied permissions. The permissions are capped instead when the scope covers all repositories.
+ */
+func (cumulativeScope *Scope) subtract(deniedScope Scope) []string {
+	changes := []string{}
+
+	if deniedScope.isEmpty() {
+		if !cumulativeScope.isEmpty() {
+			changes = append(changes, "revoked the whole scope")
+		}
+		cumulativeScope.Repositories = []string{}
+		cumulativeScope.Permissions = github.InstallationPermissions{}
+		return changes
+	}
+
+	if len(deniedScope.Repositories) > 0 && !(&Scope{Permissions: deniedScope.Permissions}).isEmpty() {
+		if len(cumulativeScope.deniedPermissions(deniedScope)) == 0 {
+			return changes
+		}
+		if len(cumulativeScope.Repositories) == 0 {
+			return cumulativeScope.subtract(Scope{Permissions: deniedScope.Permissions})
+		}
+		return cumulativeScope.subtract(Scope{Repositories: deniedScope.Repositories})
+	}
+
+	if len(deniedScope.Repositories) > 0 {
+		if len(cumulativeScope.Repositories) == 0 {
+			if cumulativeScope.stripRepositoryPermissions() {
+				changes = append(changes, fmt.Sprintf("removed repository permissions as repositories [%s] can't be excluded from a scope covering all repositories", strings.Join(deniedScope.Repositories, ", ")))
+			}
+		} else {
+			remainingRepositories := []string{}
+			removedRepositories := []string{}
+			for _, repository := range cumulativeScope.Repositories {
+				if containsFold(deniedScope.Repositories, repository) {
+					removedRepositories = append(removedRepositories, repository)
+				} else {
+					remainingRepositories = append(remainingRepositories, repository)
+				}
+			}
+			cumulativeScope.Repositories = remainingRepositories
+
+			if len(removedRepositories) > 0 {
+				changes = append(changes, fmt.Sprintf("removed repositories [%s]", strings.Join(removedRepositories, ", ")))
+			}
+			if len(remainingRepositories) == 0 && cumulativeScope.stripRepositoryPermissions() {
+				changes = append(changes, "removed repository permissions as no repository is left")
+			}
+		}
+	}
+
+	// Get the list of fields from the struct github.InstallationPermissions
+	fields := reflect.VisibleFields(reflect.TypeOf(struct{ github.InstallationPermissions }{}))
+
+	reflectCumulativeScope := reflect.ValueOf(&cumulativeScope.Permissions).Elem()
+	reflectDeniedScope := reflect.ValueOf(&deniedScope.Permissions).Elem()
+
+	for _, field := range fields {
+		deniedValue := reflectDeniedScope.FieldByName(field.Name)
+		cumulativeValue := reflectCumulativeScope.FieldByName(field.Name)
+
+		// Has this field been denied and granted?
+		if !deniedValue.IsValid() || deniedValue.IsZero() || !cumulativeValue.IsValid() || cumulativeValue.IsZero() {
+			continue
+		}
+
+		deniedRank := permissionRank[deniedValue.Elem().String()]
+		cumulativeValueString := cumulativeValue.Elem().String()
+		if permissionRank[cumulativeValueString] < deniedRank {
+			continue
+		}
+
+		if deniedRank == 0 {
+			cumulativeValue.Set(reflect.Zero(cumulativeValue.Type()))
+			changes = append(changes, fmt.Sprintf("removed permission %s", field.Name))
+		} else {
+			// Set a new pointer so we don't update a value shared with another scope
+			cumulativeValue.Set(reflect.New(cumulativeValue.Type().Elem()))
+			cumulativeValue.Elem().SetString(permissionLevels[deniedRank-1])
+			changes = append(changes, fmt.Sprintf("capped permission %s from %s to %s", field.Name, cumulativeValueString, permissionLevels[deniedRank-1]))
+		}
+	}
+
+	return changes
+}
+
+/*
+ * Get the names of the permissions granted by the scope at or above the level denied by another scope
+ */
+func (scope *Scope) deniedPermissions(deniedScope Scope) []string {
+	denied := []string{}
+	fields := reflect.VisibleFields(reflect.TypeOf(struct{ github.InstallationPermissions }{}))
+	reflectScope := reflect.ValueOf(&scope.Permissions).Elem()
+	reflectDeniedScope := reflect.ValueOf(&deniedScope.Permissions).Elem()
+
+	for _, field := range fields {
+		deniedValue := reflectDeniedScope.FieldByName(field.Name)
+		value := reflectScope.FieldByName(field.Name)
+		if !deniedValue.IsValid() || deniedValue.IsZero() || !value.IsValid() || value.IsZero() {
+			continue
+		}
+		if permissionRank[value.Elem().String()] >= permissionRank[deniedValue.Elem().String()] {
+			denied = append(denied, field.Name)
+		}
+	}
+	return denied
+}
+
+/*
+ * Remove all the permissions which are not organization level permissions. Return true if any permission was removed.
+ */
+func (scope *Scope) stripRepositoryPermissions() bool {
+	stripped := false
+	// Get all the fields of the Permissions struct
+	fields := reflect.VisibleFields(reflect.TypeOf(struct{ github.InstallationPermissions }{}))
+	reflectScope := reflect.ValueOf(&scope.Permissions).Elem()
+
+	for _, field := range fields {
+		value := reflectScope.FieldByName(field.Name)
+
+		if value.IsValid() && !value.IsZero() && !strings.HasPrefix(field.Name, "Organization") {
+			value.Set(reflect.Zero(value.Type()))
+			stripped = true
+		}
+	}
+	return stripped
+}
+
+func containsFold(values []string, value string) bool {
+	for _, item := range values {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
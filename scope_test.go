@@ -134,3 +134,135 @@ func TestString(t *testing.T) {
 		t.Error("Expected baseScope.String to be {repositories: [test1, test2], permissions: {Checks: write, Contents: read, OrganizationProjects: admin}}, but got", baseScopeStr)
 	}
 }
+
+func TestSubtractEmptyScope(t *testing.T) {
+	read := "read"
+	baseScope := Scope{
+		Repositories: []string{"test1"},
+		Permissions: github.InstallationPermissions{
+			Contents: &read,
+		},
+	}
+	changes := baseScope.subtract(*NewScope())
+
+	if !baseScope.isEmpty() {
+		t.Error("Expected an empty denied scope to revoke the whole scope, but got", baseScope.String())
+	}
+	if len(changes) != 1 {
+		t.Error("Expected one change, but got", changes)
+	}
+}
+
+func TestSubtractRepositories(t *testing.T) {
+	write := "write"
+	read := "read"
+	baseScope := Scope{
+		Repositories: []string{"test1", "test2"},
+		Permissions: github.InstallationPermissions{
+			Contents:                   &write,
+			OrganizationAdministration: &read,
+		},
+	}
+	baseScope.subtract(Scope{Repositories: []string{"TEST1"}})
+
+	if !reflect.DeepEqual(baseScope.Repositories, []string{"test2"}) {
+		t.Error("Expected baseScope.Repositories to be [test2], but got", baseScope.Repositories)
+	}
+
+	baseScope.subtract(Scope{Repositories: []string{"test2"}})
+	expectedPermissions := github.InstallationPermissions{
+		OrganizationAdministration: &read,
+	}
+	if len(baseScope.Repositories) != 0 {
+		t.Error("Expected baseScope.Repositories to be empty, but got", baseScope.Repositories)
+	}
+	if !reflect.DeepEqual(baseScope.Permissions, expectedPermissions) {
+		t.Error("Expected repository permissions to be removed when no repository is left, but got", baseScope.String())
+	}
+}
+
+func TestSubtractRepositoriesFromAllRepositories(t *testing.T) {
+	write := "write"
+	baseScope := Scope{
+		Repositories: []string{},
+		Permissions: github.InstallationPermissions{
+			Contents: &write,
+		},
+	}
+	baseScope.subtract(Scope{Repositories: []string{"test1"}})
+
+	if !baseScope.isEmpty() {
+		t.Error("Expected repository permissions to be removed, but got", baseScope.String())
+	}
+}
+
+func TestSubtractPermissions(t *testing.T) {
+	read := "read"
+	write := "write"
+	admin := "admin"
+
+	baseScope := Scope{
+		Repositories: []string{"test1"},
+		Permissions: github.InstallationPermissions{
+			Contents:             &write,
+			Actions:              &read,
+			Checks:               &read,
+			OrganizationProjects: &admin,
+		},
+	}
+
+	deniedScope := Scope{
+		Permissions: github.InstallationPermissions{
+			Contents:             &write,
+			Actions:              &write,
+			Checks:               &read,
+			OrganizationProjects: &write,
+			Secrets:              &read,
+		},
+	}
+	changes := baseScope.subtract(deniedScope)
+
+	expectedPermissions := github.InstallationPermissions{
+		Contents:             &read,
+		Actions:              &read,
+		OrganizationProjects: &read,
+	}
+	if !reflect.DeepEqual(baseScope.Permissions, expectedPermissions) {
+		t.Error("Expected scope.Permissions to be", expectedPermissions, ", but got", baseScope.String())
+	}
+	if !reflect.DeepEqual(baseScope.Repositories, []string{"test1"}) {
+		t.Error("Expected baseScope.Repositories to be [test1], but got", baseScope.Repositories)
+	}
+	if len(changes) != 3 {
+		t.Error("Expected 3 changes, but got", changes)
+	}
+}
+
+func TestSubtractPermissionsOnRepositories(t *testing.T) {
+	read := "read"
+	write := "write"
+	deniedScope := Scope{
+		Repositories: []string{"test1"},
+		Permissions:  github.InstallationPermissions{Contents: &write},
+	}
+
+	// The other repositories keep the permission
+	baseScope := Scope{Repositories: []string{"test1", "test2"}, Permissions: github.InstallationPermissions{Contents: &write}}
+	baseScope.subtract(deniedScope)
+	if !reflect.DeepEqual(baseScope.Repositories, []string{"test2"}) || baseScope.Permissions.GetContents() != "write" {
+		t.Error("Expected test1 to be removed and contents to stay write, but got", baseScope.String())
+	}
+
+	// Nothing is removed when the denied permission isn't granted
+	baseScope = Scope{Repositories: []string{"test1", "test2"}, Permissions: github.InstallationPermissions{Contents: &read}}
+	if changes := baseScope.subtract(deniedScope); len(changes) != 0 || len(baseScope.Repositories) != 2 {
+		t.Error("Expected the scope to be left untouched, but got", baseScope.String())
+	}
+
+	// The repositories can't be excluded from a scope covering all repositories, the permission is capped instead
+	baseScope = Scope{Repositories: []string{}, Permissions: github.InstallationPermissions{Contents: &write, Issues: &write}}
+	baseScope.subtract(deniedScope)
+	if baseScope.Permissions.GetContents() != "read" || baseScope.Permissions.GetIssues() != "write" {
+		t.Error("Expected contents to be capped to read, but got", baseScope.String())
+	}
+}
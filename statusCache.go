@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LoadStateLoading = "loading"
+	LoadStateLoaded  = "loaded"
+	LoadStateFailed  = "failed"
+)
+
+/*
+ * State of the configuration of an installation, kept across reloads
+ */
+type InstallationStatus struct {
+	Login                string     `json:"login"`
+	InstallationId       int64      `json:"installationId"`
+	State                string     `json:"state"`
+	LastAttempt          *time.Time `json:"lastAttempt,omitempty"`
+	LastSuccessfulReload *time.Time `json:"lastSuccessfulReload,omitempty"`
+	LastError            string     `json:"lastError,omitempty"`
+}
+
+type StatusCache struct {
+	cache map[string]*InstallationStatus
+	mu    sync.Mutex
+}
+
+func NewStatusCache() *StatusCache {
+	return &StatusCache{make(map[string]*InstallationStatus), sync.Mutex{}}
+}
+
+func (statusCache *StatusCache) getOrCreate(login string, installationId int64) *InstallationStatus {
+	status, ok := statusCache.cache[strings.ToUpper(login)]
+	if !ok {
+		status = &InstallationStatus{Login: login}
+		statusCache.cache[strings.ToUpper(login)] = status
+	}
+	status.InstallationId = installationId
+	return status
+}
+
+func (statusCache *StatusCache) SetLoading(login string, installationId int64) {
+	statusCache.mu.Lock()
+	defer statusCache.mu.Unlock()
+	statusCache.getOrCreate(login, installationId).State = LoadStateLoading
+}
+
+/*
+ * Record the result of a load. The time of the last successful load is kept when a load fails.
+ */
+func (statusCache *StatusCache) RecordLoad(login string, installationId int64, err error) {
+	statusCache.mu.Lock()
+	defer statusCache.mu.Unlock()
+
+	status := statusCache.getOrCreate(login, installationId)
+	now := time.Now().UTC()
+	status.LastAttempt = &now
+	if err != nil {
+		status.State = LoadStateFailed
+		status.LastError = err.Error()
+	} else {
+		status.State = LoadStateLoaded
+		status.LastSuccessfulReload = &now
+		status.LastError = ""
+	}
+}
+
+func (statusCache *StatusCache) DeleteStatus(login string) {
+	statusCache.mu.Lock()
+	defer statusCache.mu.Unlock()
+	delete(statusCache.cache, strings.ToUpper(login))
+}
+
+/*
+ * Get a copy of the status of every installation, sorted by login
+ */
+func (statusCache *StatusCache) GetStatuses() []InstallationStatus {
+	statusCache.mu.Lock()
+	defer statusCache.mu.Unlock()
+
+	statuses := make([]InstallationStatus, 0, len(statusCache.cache))
+	for _, status := range statusCache.cache {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return strings.ToUpper(statuses[i].Login) < strings.ToUpper(statuses[j].Login)
+	})
+	return statuses
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusCacheKeepsLastSuccessfulReload(t *testing.T) {
+	statusCache := NewStatusCache()
+	statusCache.SetLoading("octodemo", 1)
+	if statuses := statusCache.GetStatuses(); statuses[0].State != LoadStateLoading {
+		t.Errorf("Expected loading state, got %s", statuses[0].State)
+	}
+
+	statusCache.RecordLoad("octodemo", 1, nil)
+	statusCache.RecordLoad("OctoDemo", 1, errors.New("repository not found"))
+	statusCache.RecordLoad("major-tom", 2, nil)
+
+	statuses := statusCache.GetStatuses()
+	if len(statuses) != 2 || statuses[0].Login != "major-tom" {
+		t.Fatalf("Expected 2 statuses sorted by login, got %v", statuses)
+	}
+	if statuses[1].State != LoadStateFailed || statuses[1].LastError != "repository not found" || statuses[1].LastSuccessfulReload == nil {
+		t.Errorf("Expected failed state keeping the last successful reload, got %+v", statuses[1])
+	}
+
+	statusCache.DeleteStatus("octodemo")
+	if statuses := statusCache.GetStatuses(); len(statuses) != 1 {
+		t.Errorf("Expected status to be deleted, got %v", statuses)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	issuer, _ := newTestIssuer(t, "github", gitHubIssuerURL)
+	context := NewAppContext(nil, "", "oidc_entitlements", "", "", "", NewIssuerRegistry(issuer), nil, nil, "https://github.com", gitHubIssuerURL, "admin-secret")
+
+	recorder := httptest.NewRecorder()
+	context.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected not ready before the configurations are loaded, got %d", recorder.Code)
+	}
+
+	context.ready.Store(true)
+	recorder = httptest.NewRecorder()
+	context.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected ready once the configurations are loaded, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// The keys of every issuer are needed to serve token requests
+	context.authenticator = NewIssuerRegistry(issuer, NewGitHubIssuer("https://gitlab.com", "https://gitlab.com/oauth/discovery/keys"))
+	recorder = httptest.NewRecorder()
+	context.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected not ready without JWKS, got %d", recorder.Code)
+	}
+
+	context.statusCache.RecordLoad("octodemo", 1, errors.New("repository not found"))
+	recorder = httptest.NewRecorder()
+	context.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status StatusResponse
+	json.NewDecoder(recorder.Body).Decode(&status)
+	if recorder.Code != http.StatusOK || len(status.Installations) != 0 {
+		t.Errorf("Expected only the readiness without the admin token, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/status", nil)
+	request.Header.Set("Authorization", "Bearer admin-secret")
+	recorder = httptest.NewRecorder()
+	context.ServeHTTP(recorder, request)
+	json.NewDecoder(recorder.Body).Decode(&status)
+	if len(status.Installations) != 1 || status.Installations[0].State != LoadStateFailed {
+		t.Errorf("Expected status of the failed installation, got %+v", status)
+	}
+
+	recorder = httptest.NewRecorder()
+	context.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected healthz to be ok, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTokenRequestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	context := &AppContext{authenticator: NewIssuerRegistry(NewGitHubIssuer(gitHubIssuerURL, ""))}
+	handler := otelhttp.NewHandler(context, "github-oidc-auth-app")
+
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(`{"login": "octodemo", "oidcToken": "not-a-token"}`))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.Name())
+		if span.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Expected span %s to be part of the incoming trace, got trace %s", span.Name(), span.SpanContext().TraceID())
+		}
+	}
+	if len(spans) != 2 || names[0] != "authenticate" {
+		t.Errorf("Expected an authenticate span within the request span, got %v", names)
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v53/github"
+)
+
+// The permission level of an organization permission is set by a folder, a file without it would keep the repository permissions on all the repositories
+const missingOrgLevelFolderMessage = "file is within an organization permission folder but not within a 'read', 'write' or 'admin' folder"
+
+type ValidationIssue struct {
+	Source  string `json:"source"`
+	Message string `json:"message"`
+	// Line of the file the issue was found at, 0 when the issue is about the whole file
+	Line int `json:"line,omitempty"`
+}
+
+func (issue ValidationIssue) String() string {
+	if issue.Line > 0 {
+		return fmt.Sprintf("%s (line %d): %s", issue.Source, issue.Line, issue.Message)
+	}
+	return fmt.Sprintf("%s: %s", issue.Source, issue.Message)
+}
+
+func (issue ValidationIssue) Error() string {
+	return issue.String()
+}
+
+/*
+ * Get the names used in JSON for the fields of a struct
+ */
+func jsonFieldNames(value interface{}) map[string]bool {
+	names := map[string]bool{}
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(value)) {
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+/*
+ * Get the line of an offset within a content, starting at 1
+ */
+func lineAt(content []byte, offset int64) int {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	return bytes.Count(content[:offset], []byte("\n")) + 1
+}
+
+/*
+ * Get the line of the first occurrence of a JSON key within a content, 0 when the key can't be found
+ */
+func keyLine(content []byte, key string) int {
+	keyRegex := regexp.MustCompile(regexp.QuoteMeta(fmt.Sprintf("%q", key)) + `\s*:`)
+	location := keyRegex.FindIndex(content)
+	if location == nil {
+		return 0
+	}
+	return lineAt(content, int64(location[0]))
+}
+
+/*
+ * Get the line of a JSON syntax or type error, 0 when the error doesn't carry an offset
+ */
+func errorLine(content []byte, err error) int {
+	var syntaxError *json.SyntaxError
+	if errors.As(err, &syntaxError) {
+		return lineAt(content, syntaxError.Offset)
+	}
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		return lineAt(content, typeError.Offset)
+	}
+	return 0
+}
+
+/*
+ * Get the line at which each element of a JSON array starts
+ */
+func arrayElementLines(content []byte) ([]int, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, fmt.Errorf("expected an array of entitlements")
+	}
+
+	lines := []int{}
+	for decoder.More() {
+		// The offset is right after the previous token, skip the separator to get to the element
+		start := decoder.InputOffset()
+		for start < int64(len(content)) && bytes.ContainsRune([]byte(" \t\r\n,"), rune(content[start])) {
+			start++
+		}
+		lines = append(lines, lineAt(content, start))
+
+		var element json.RawMessage
+		if err := decoder.Decode(&element); err != nil {
+			return nil, err
+		}
+	}
+	return lines, nil
+}
+
+/*
+ * Move the issues found within a part of a file to the line this part starts at
+ */
+func shiftIssues(issues []ValidationIssue, startLine int) []ValidationIssue {
+	for index := range issues {
+		if issues[index].Line == 0 {
+			issues[index].Line = 1
+		}
+		issues[index].Line += startLine - 1
+	}
+	return issues
+}
+
+/*
+ * Validate the JSON content of a single entitlement. Lines are relative to the start of the content.
+ */
+func validateEntitlementJSON(source string, content []byte) []ValidationIssue {
+	issues := []ValidationIssue{}
+
+	var rawEntitlement map[string]json.RawMessage
+	if err := json.Unmarshal(content, &rawEntitlement); err != nil {
+		return append(issues, ValidationIssue{Source: source, Message: fmt.Sprintf("invalid JSON: %s", err), Line: errorLine(content, err)})
+	}
+
+	knownKeys := jsonFieldNames(Entitlement{})
+	for _, key := range sortedKeys(rawEntitlement) {
+		if !knownKeys[key] {
+			issues = append(issues, ValidationIssue{Source: source, Message: fmt.Sprintf("unknown claim key '%s'", key), Line: keyLine(content, key)})
+		}
+	}
+
+	if rawScopes, ok := rawEntitlement["scopes"]; ok {
+		scopesLine := lineAt(content, int64(bytes.Index(content, rawScopes)))
+		issues = append(issues, shiftIssues(validateScopesJSON(source, rawScopes), scopesLine)...)
+	}
+
+	var entitlement Entitlement
+	if err := json.Unmarshal(content, &entitlement); err != nil {
+		return append(issues, ValidationIssue{Source: source, Message: fmt.Sprintf("invalid entitlement: %s", err), Line: errorLine(content, err)})
+	}
+	// The decoded entitlement doesn't know where its values were, the issues are reported at the start of the entitlement
+	return append(issues, shiftIssues(entitlement.validate(source), 1)...)
+}
+
+/*
+ * Check the keys of the scopes and permissions objects
+ */
+func validateScopesJSON(source string, content []byte) []ValidationIssue {
+	issues := []ValidationIssue{}
+
+	var rawScopes map[string]json.RawMessage
+	if err := json.Unmarshal(content, &rawScopes); err != nil {
+		return append(issues, ValidationIssue{Source: source, Message: fmt.Sprintf("invalid scopes: %s", err), Line: errorLine(content, err)})
+	}
+
+	knownKeys := jsonFieldNames(Scope{})
+	for _, key := range sortedKeys(rawScopes) {
+		if !knownKeys[key] {
+			issues = append(issues, ValidationIssue{Source: source, Message: fmt.Sprintf("unknown scopes key '%s'", key), Line: keyLine(content, key)})
+		}
+	}
+
+	if rawPermissions, ok := rawScopes["permissions"]; ok {
+		var permissions map[string]json.RawMessage
+		if err := json.Unmarshal(rawPermissions, &permissions); err != nil {
+			return append(issues, ValidationIssue{Source: source, Message: fmt.Sprintf("invalid permissions: %s", err), Line: keyLine(content, "permissions")})
+		}
+
+		knownPermissions := jsonFieldNames(github.InstallationPermissions{})
+		for _, name := range sortedKeys(permissions) {
+			if !knownPermissions[name] {
+				issues = append(issues, ValidationIssue{Source: source, Message: fmt.Sprintf("unknown permission '%s'", name), Line: keyLine(content, name)})
+			}
+		}
+	}
+	return issues
+}
+
+/*
+ * Check the values of an entitlement once decoded
+ */
+func (e Entitlement) validate(source string) []ValidationIssue {
+	issues := []ValidationIssue{}
+
+	if !e.isAllow() && !e.isDeny() {
+		issues = append(issues, ValidationIssue{Source: source, Message: fmt.Sprintf("invalid effect '%s', expected '%s' or '%s'", e.Effect, EffectAllow, EffectDeny)})
+	}
+
+	for _, matcher := range e.claimMatchers() {
+		if _, err := matcher.compile(); err != nil {
+			issues = append(issues, ValidationIssue{Source: source, Message: fmt.Sprintf("invalid pattern for claim '%s': %s", matcher.Claim, err)})
+		}
+	}
+
+	// Get the list of fields from the struct github.InstallationPermissions
+	fields := reflect.VisibleFields(reflect.TypeOf(github.InstallationPermissions{}))
+	reflectPermissions := reflect.ValueOf(&e.Scopes.Permissions).Elem()
+
+	for _, field := range fields {
+		value := reflectPermissions.FieldByName(field.Name)
+		if !value.IsValid() || value.IsZero() {
+			continue
+		}
+		if _, ok := permissionRank[value.Elem().String()]; !ok {
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			issues = append(issues, ValidationIssue{Source: source, Message: fmt.Sprintf("invalid level '%s' for permission '%s', expected one of %s", value.Elem().String(), name, strings.Join(permissionLevels, ", "))})
+		}
+	}
+	return issues
+}
+
+/*
+ * Validate a single file configuration, i.e. an array of entitlements
+ */
+func validateConfigFile(path string) []ValidationIssue {
+	issues := []ValidationIssue{}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return append(issues, ValidationIssue{Source: path, Message: err.Error()})
+	}
+	return validateConfigContent(path, content)
+}
+
+/*
+ * Validate the content of a single file configuration
+ */
+func validateConfigContent(path string, content []byte) []ValidationIssue {
+	issues := []ValidationIssue{}
+
+	var rawEntitlements []json.RawMessage
+	if err := json.Unmarshal(content, &rawEntitlements); err != nil {
+		return append(issues, ValidationIssue{Source: path, Message: fmt.Sprintf("invalid JSON: %s", err), Line: errorLine(content, err)})
+	}
+	lines, err := arrayElementLines(content)
+	if err != nil {
+		return append(issues, ValidationIssue{Source: path, Message: fmt.Sprintf("invalid JSON: %s", err), Line: errorLine(content, err)})
+	}
+
+	for index, rawEntitlement := range rawEntitlements {
+		issues = append(issues, shiftIssues(validateEntitlementJSON(fmt.Sprintf("%s[%d]", path, index), rawEntitlement), lines[index])...)
+	}
+	return issues
+}
+
+/*
+ * Validate a repository based configuration, the root being a folder within the file system
+ */
+func validateConfigFolder(fsys fs.FS, root string) []ValidationIssue {
+	issues := []ValidationIssue{}
+
+	err := fs.WalkDir(fsys, root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		issues = append(issues, validateFolderFile(fsys, root, path)...)
+		return nil
+	})
+	if err != nil {
+		issues = append(issues, ValidationIssue{Source: root, Message: err.Error()})
+	}
+	return issues
+}
+
+/*
+ * Validate a single file of a repository based configuration
+ */
+func validateFolderFile(fsys fs.FS, root string, path string) []ValidationIssue {
+	issues := []ValidationIssue{}
+	// Regex to find the section right after /organization/<permissionName>/ in the path
+	orgRegex := regexp.MustCompile(`\/organization\/[^\/]+\/(.*)$`)
+	// Regex to check that the path starts with a permission level folder
+	levelRegex := regexp.MustCompile(`^(read|write|admin)\/`)
+
+	if !strings.HasSuffix(path, ".json") {
+		return issues
+	}
+
+	if path == pathpkg.Join(root, policyFileName) {
+		policyFile, err := fsys.Open(path)
+		if err != nil {
+			return append(issues, ValidationIssue{Source: path, Message: err.Error()})
+		}
+		defer policyFile.Close()
+		if _, err := decodePolicy(path, policyFile); err != nil {
+			issues = append(issues, ValidationIssue{Source: path, Message: strings.TrimPrefix(err.Error(), path+": ")})
+		}
+		return issues
+	}
+
+	if isIgnoredFolder(pathpkg.Dir(path)) {
+		return append(issues, ValidationIssue{Source: path, Message: fmt.Sprintf("file is ignored as entitlement files can't be stored directly in the '%s' folder", pathpkg.Base(pathpkg.Dir(path)))})
+	}
+	// The path is relative to the root of the file system, the folders are matched from there
+	orgSubPath := orgRegex.FindStringSubmatch("/" + path)
+	if orgSubPath != nil && !levelRegex.MatchString(orgSubPath[1]) {
+		issues = append(issues, ValidationIssue{Source: path, Message: missingOrgLevelFolderMessage})
+	}
+
+	content, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return append(issues, ValidationIssue{Source: path, Message: err.Error()})
+	}
+	return append(issues, validateEntitlementJSON(path, content)...)
+}
+
+/*
+ * Validate a configuration stored either in a folder or in a single file
+ */
+func validateConfig(path string) []ValidationIssue {
+	info, err := os.Stat(path)
+	if err != nil {
+		return []ValidationIssue{{Source: path, Message: err.Error()}}
+	}
+
+	var issues []ValidationIssue
+	if info.IsDir() {
+		issues = validateConfigFolder(os.DirFS(path), ".")
+		// Report the files with the path they were given with
+		for index := range issues {
+			issues[index].Source = filepath.Join(path, issues[index].Source)
+		}
+	} else {
+		issues = validateConfigFile(path)
+	}
+
+	// Some checks can only happen once the folder semantic has been applied
+	config, err := loadLocalConfig(path)
+	if err == nil {
+		for _, entitlement := range config.Quarantined {
+			issues = append(issues, ValidationIssue{Source: entitlement.Source, Message: "entitlement is ignored as it doesn't pin repository_owner, repository_owner_id, repository_id or repository and would match any repository on GitHub, set allow_any_repository to true if this is intended", Line: entitlement.Line})
+		}
+	}
+	return issues
+}
+
+func sortedKeys(values map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
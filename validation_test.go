@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestValidateGoodRepoConfig(t *testing.T) {
+	issues := validateConfig("test/good-repo")
+	if len(issues) != 0 {
+		t.Errorf("Expected no issue, but got %v", issues)
+	}
+}
+
+func TestValidateInvalidRepoConfig(t *testing.T) {
+	issues := validateConfig("test/invalid-repo")
+
+	expectedIssues := []ValidationIssue{
+		{"test/invalid-repo/generic.json", "unknown claim key 'enviroment'", 3},
+		{"test/invalid-repo/generic.json", "unknown permission 'contentz'", 9},
+		{"test/invalid-repo/generic.json", "invalid level 'delete' for permission 'issues', expected one of read, write, admin", 1},
+		{"test/invalid-repo/organization/administration/no-level.json", "file is within an organization permission folder but not within a 'read', 'write' or 'admin' folder", 0},
+		{"test/invalid-repo/owner/misplaced.json", "file is ignored as entitlement files can't be stored directly in the 'owner' folder", 0},
+		{"test/invalid-repo/repositories/codespace-oddity/bad-regex.json", "invalid pattern for claim 'ref': error parsing regexp: missing closing ): `refs/heads/(main`", 1},
+	}
+
+	if !reflect.DeepEqual(expectedIssues, issues) {
+		t.Errorf("Expected issues to be %v, but got %v", expectedIssues, issues)
+	}
+}
+
+func TestValidateGoodFileConfig(t *testing.T) {
+	issues := validateConfig("test/multiple-match.json")
+	if len(issues) != 0 {
+		t.Errorf("Expected no issue, but got %v", issues)
+	}
+}
+
+func TestValidateInvalidFileConfig(t *testing.T) {
+	issues := validateConfig("test/invalid-file.json")
+
+	expectedIssues := []ValidationIssue{
+		{"test/invalid-file.json[1]", "unknown scopes key 'repository'", 14},
+		{"test/invalid-file.json[1]", "invalid effect 'maybe', expected 'allow' or 'deny'", 10},
+	}
+
+	if !reflect.DeepEqual(expectedIssues, issues) {
+		t.Errorf("Expected issues to be %v, but got %v", expectedIssues, issues)
+	}
+}
+
+func TestValidateMissingConfig(t *testing.T) {
+	issues := validateConfig("test/does-not-exist")
+	if len(issues) != 1 {
+		t.Errorf("Expected one issue, but got %v", issues)
+	}
+}
+
+func TestValidateInvalidJSONLine(t *testing.T) {
+	issues := validateConfigContent("oidc_entitlements.json", []byte("[\n  {\n    \"repository_owner\": \"major-tom\",\n  }\n]"))
+
+	if len(issues) != 1 || issues[0].Line != 4 {
+		t.Errorf("Expected one issue on line 4, but got %v", issues)
+	}
+}
+
+func TestArrayElementLines(t *testing.T) {
+	content, err := os.ReadFile("test/invalid-file.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines, err := arrayElementLines(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLines := []int{2, 10}
+	if !reflect.DeepEqual(expectedLines, lines) {
+		t.Errorf("Expected lines to be %v, but got %v", expectedLines, lines)
+	}
+}